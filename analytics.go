@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// analyticsPoint summarises the last roster pulled on a day.  Idle here is
+// the plain maxIdleDays limit, as of that pull.
+type analyticsPoint struct {
+	Date            string `json:"date"`
+	Members         int    `json:"members"`
+	Active          int    `json:"active"`
+	Idle            int    `json:"idle"`
+	RoleHolders     int    `json:"roleHolders"`
+	IdleRoleHolders int    `json:"idleRoleHolders"`
+}
+
+type analyticsBucket struct {
+	Label string `json:"label"`
+	Count int    `json:"count"`
+}
+
+// joinCohort is everyone seen joining in a month, and how many remain.
+type joinCohort struct {
+	Month     string  `json:"month"`
+	Joined    int     `json:"joined"`
+	Remaining int     `json:"remaining"`
+	Retention float64 `json:"retention"`
+}
+
+type operatorThroughput struct {
+	Operator string `json:"operator"`
+	Stripped int    `json:"stripped"`
+	Kicked   int    `json:"kicked"`
+}
+
+type analytics struct {
+	Generated   time.Time `json:"generated"`
+	Days        int       `json:"days"`
+	MaxIdleDays int       `json:"maxIdleDays"`
+
+	Timeline          []analyticsPoint     `json:"timeline"`
+	LogonRecency      []analyticsBucket    `json:"logonRecency"`
+	RoleHolderRecency []analyticsBucket    `json:"roleHolderRecency"`
+	Cohorts           []joinCohort         `json:"cohorts"`
+	Throughput        []operatorThroughput `json:"throughput"`
+}
+
+// recencyBuckets are the upper bounds, in days, of the logon histograms.
+var recencyBuckets = []struct {
+	label string
+	days  int
+}{
+	{"Today", 1},
+	{"This week", 7},
+	{"1-4 weeks", 30},
+	{"1-3 months", 90},
+	{"3-6 months", 180},
+	{"6-12 months", 365},
+	{"Over a year", 0},
+}
+
+func recencyHistogram(members []MemberTrackingMember, now time.Time, include func(mt MemberTrackingMember) bool) []analyticsBucket {
+	hist := make([]analyticsBucket, len(recencyBuckets))
+	for i, b := range recencyBuckets {
+		hist[i].Label = b.label
+	}
+
+	for _, mt := range members {
+		if !include(mt) {
+			continue
+		}
+		days := int(now.Sub(mt.LogonDateTime.Time) / (24 * time.Hour))
+		for i, b := range recencyBuckets {
+			if b.days == 0 || days < b.days {
+				hist[i].Count++
+				break
+			}
+		}
+	}
+	return hist
+}
+
+// buildAnalytics summarises the stored roster history and audit log over the
+// last days.
+func buildAnalytics(days int) (*analytics, error) {
+	purgeLock.RLock()
+	idleLimit := maxIdle
+	current := lastPull.members
+	purgeLock.RUnlock()
+
+	now := time.Now()
+	since := now.Add(-time.Duration(days) * 24 * time.Hour)
+	a := &analytics{Generated: now, Days: days, MaxIdleDays: int(idleLimit / (24 * time.Hour))}
+
+	hasRoles := func(mt MemberTrackingMember) bool { return memberRoles(mt).any() }
+
+	// Timeline and cohorts come from the last snapshot of each day.
+	joinMonth := map[int64]string{}
+	var latest []MemberTrackingMember
+	err := eachDailySnapshot(since, func(pulled time.Time, members []MemberTrackingMember) {
+		p := analyticsPoint{Date: pulled.Format(dateFormat), Members: len(members)}
+		for _, mt := range members {
+			idle := pulled.Sub(mt.LogonDateTime.Time) > idleLimit
+			if idle {
+				p.Idle++
+			} else {
+				p.Active++
+			}
+			if hasRoles(mt) {
+				p.RoleHolders++
+				if idle {
+					p.IdleRoleHolders++
+				}
+			}
+			if !mt.StartDateTime.Before(since) {
+				joinMonth[mt.CharacterID] = mt.StartDateTime.Format("2006-01")
+			}
+		}
+
+		a.Timeline = append(a.Timeline, p)
+		latest = members
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := map[int64]bool{}
+	for _, mt := range latest {
+		remaining[mt.CharacterID] = true
+	}
+	cohorts := map[string]*joinCohort{}
+	for id, month := range joinMonth {
+		c, ok := cohorts[month]
+		if !ok {
+			c = &joinCohort{Month: month}
+			cohorts[month] = c
+		}
+		c.Joined++
+		if remaining[id] {
+			c.Remaining++
+		}
+	}
+	for _, c := range cohorts {
+		c.Retention = float64(c.Remaining) / float64(c.Joined)
+		a.Cohorts = append(a.Cohorts, *c)
+	}
+	sort.Slice(a.Cohorts, func(i, j int) bool { return a.Cohorts[i].Month < a.Cohorts[j].Month })
+
+	// Histograms describe the roster as it is now.
+	a.LogonRecency = recencyHistogram(current, now, func(MemberTrackingMember) bool { return true })
+	a.RoleHolderRecency = recencyHistogram(current, now, hasRoles)
+
+	// Throughput comes from confirmations in the audit log.
+	ops := map[string]*operatorThroughput{}
+	for _, c := range completedRows(since, now) {
+		op, ok := ops[c.Operator]
+		if !ok {
+			op = &operatorThroughput{Operator: c.Operator}
+			ops[c.Operator] = op
+		}
+		if c.Action == "stripped" {
+			op.Stripped++
+		} else {
+			op.Kicked++
+		}
+	}
+	for _, op := range ops {
+		a.Throughput = append(a.Throughput, *op)
+	}
+	sort.Slice(a.Throughput, func(i, j int) bool {
+		ti, tj := a.Throughput[i], a.Throughput[j]
+		return ti.Stripped+ti.Kicked > tj.Stripped+tj.Kicked
+	})
+
+	return a, nil
+}
+
+// svgLine plots values as polyline points in a width by height box, scaled
+// so max is at the top.
+func svgLine(values []int, max, width, height int) string {
+	if len(values) == 0 || max == 0 {
+		return ""
+	}
+
+	var points []string
+	for i, v := range values {
+		x := 0
+		if len(values) > 1 {
+			x = i * width / (len(values) - 1)
+		}
+		y := height - v*height/max
+		points = append(points, fmt.Sprintf("%d,%d", x, y))
+	}
+	return strings.Join(points, " ")
+}
+
+func analyticsDays(r *http.Request) int {
+	if d, err := strconv.Atoi(r.FormValue("days")); err == nil && d > 0 {
+		return d
+	}
+	return 90
+}
+
+func handleAnalytics(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	a, err := buildAnalytics(analyticsDays(r))
+	if err != nil {
+		log.Printf("Failed to build analytics: %s", err)
+		http.Error(w, "Couldn't read roster history.", http.StatusInternalServerError)
+		return
+	}
+
+	type AnalyticsData struct {
+		Title string
+		*analytics
+
+		// Lines are SVG polyline points for the timeline chart.
+		MembersLine, ActiveLine, IdleLine, RoleIdleLine string
+
+		// Totals scale the histogram bars.
+		LogonTotal, RoleTotal int
+	}
+	ad := AnalyticsData{Title: "Analytics", analytics: a}
+
+	for _, b := range a.LogonRecency {
+		ad.LogonTotal += b.Count
+	}
+	for _, b := range a.RoleHolderRecency {
+		ad.RoleTotal += b.Count
+	}
+
+	var members, active, idle, roleIdle []int
+	max := 0
+	for _, p := range a.Timeline {
+		members = append(members, p.Members)
+		active = append(active, p.Active)
+		idle = append(idle, p.Idle)
+		roleIdle = append(roleIdle, p.IdleRoleHolders)
+		if p.Members > max {
+			max = p.Members
+		}
+	}
+	ad.MembersLine = svgLine(members, max, 800, 200)
+	ad.ActiveLine = svgLine(active, max, 800, 200)
+	ad.IdleLine = svgLine(idle, max, 800, 200)
+	ad.RoleIdleLine = svgLine(roleIdle, max, 800, 200)
+
+	renderPage(w, "analytics", ad)
+}
+
+func handleAnalyticsJSON(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	a, err := buildAnalytics(analyticsDays(r))
+	if err != nil {
+		log.Printf("Failed to build analytics: %s", err)
+		http.Error(w, "Couldn't read roster history.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(a)
+	if err != nil {
+		log.Printf("Failed to write analytics: %s", err)
+	}
+}
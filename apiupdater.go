@@ -1,15 +1,14 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"database/sql"
 	"encoding/gob"
 	"encoding/xml"
 	"errors"
 	"fmt"
 	"log"
-	"net/http"
 	"strings"
 	"sync"
 	"time"
@@ -25,14 +24,76 @@ type purgeMember struct {
 	Joined    time.Time
 	LastLogin time.Time
 	ShipType  string
+	Title     string
+	Base      string
+	Location  string
 	Roles     bool
 	Claimed   time.Time
+	ClaimedBy string
 	Stripped  time.Time
 	Purged    bool
 	Reason    string
 
+	// Main is the name of this character's main if it's an alt, Alts the
+	// alts in corp if it's a main.
+	Main string
+	Alts []string
+
+	// MainQueued is set for alts queued because of their main.
+	MainQueued bool
+
+	// RoleSets are the roles held as of the last pull, RolesAtStrip those
+	// held when the strip was confirmed.  StillHeld lists roles left over
+	// after a strip that didn't take.
+	RoleSets     roleSets
+	RolesAtStrip roleSets
+	StillHeld    string
+
+	// RegisteredBy names the registration backends that vouched for this
+	// character when several are configured.
+	RegisteredBy string
+
+	// Idle, Unregistered, Targeted and Manual record why the member was
+	// queued.
+	Idle         bool
+	Unregistered bool
+	Targeted     bool
+	Manual       bool
+
+	// Priority orders the queue, highest first.  Only manual entries set it.
+	Priority int
+
+	// RegistrationUnknown is set when registration couldn't be checked.
+	// Such members are never handed out unless idle.
+	RegistrationUnknown bool
+
 	// May god have mercy on my soul.
-	IsRegistered func() bool
+	IsRegistered func() (bool, error)
+}
+
+// actionable reports whether m may be handed out for stripping or booting,
+// which requires a reason that doesn't depend on an unknown registration.
+func (m *purgeMember) actionable() bool {
+	return m.Idle || m.Targeted || m.Manual || !m.RegistrationUnknown
+}
+
+// recheckRegistration confirms an unregistered member is still unregistered
+// before it's handed out.  A failed check marks the registration unknown.
+func (m *purgeMember) recheckRegistration() (registered bool) {
+	// IsRegistered is nil for registered characters, and registering
+	// doesn't save a targeted one.
+	if m.IsRegistered == nil || m.Targeted || m.Manual {
+		return false
+	}
+
+	registered, err := m.IsRegistered()
+	if err != nil {
+		log.Printf("Couldn't check registration of %s: %s", m.Name, err)
+		m.RegistrationUnknown = true
+		return !m.Idle
+	}
+	m.RegistrationUnknown = false
+	return registered
 }
 
 type MemberTrackingMember struct {
@@ -50,6 +111,9 @@ type MemberTrackingMember struct {
 	ShipType       string  `xml:"shipType,attr"`
 	Roles          int64   `xml:"roles,attr"`
 	GrantableRoles int64   `xml:"grantableRoles,attr"`
+
+	// Security holds the full role sets when member security is available.
+	Security *roleSets `xml:"-"`
 }
 
 func init() {
@@ -59,8 +123,8 @@ func init() {
 var toBePurged = map[int64]*purgeMember{}
 var purgeLock sync.RWMutex
 
-var exemptHulls = []string{"Aeon", "Nyx", "Hel", "Wyvern", "Avatar", "Erebus",
-	"Ragnarok", "Leviathan"}
+// saveWG tracks background state saves so shutdown can wait for them.
+var saveWG sync.WaitGroup
 
 func saveState() {
 	purgeLock.Lock()
@@ -76,11 +140,43 @@ func saveState() {
 			return err
 		}
 
-		return b.Put([]byte("state"), buf.Bytes())
+		err = b.Put([]byte("state"), buf.Bytes())
+		if err != nil {
+			return err
+		}
+
+		buf = &bytes.Buffer{}
+		err = gob.NewEncoder(buf).Encode(remindersSent)
+		if err != nil {
+			return err
+		}
+		err = b.Put([]byte("reminders"), buf.Bytes())
+		if err != nil {
+			return err
+		}
+
+		buf = &bytes.Buffer{}
+		err = gob.NewEncoder(buf).Encode(confirmations)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte("confirmations"), buf.Bytes())
 	})
 	if err != nil {
 		log.Printf("Failed to save state: %s", err)
 	}
+
+	updateMemberIndex()
+}
+
+// queueSave writes the current state in the background.  Callers holding
+// purgeLock must use this rather than saveState directly.
+func queueSave() {
+	saveWG.Add(1)
+	go func() {
+		defer saveWG.Done()
+		saveState()
+	}()
 }
 
 func loadState() {
@@ -101,12 +197,26 @@ func loadState() {
 		buf := bytes.NewBuffer(gobbed)
 		g := gob.NewDecoder(buf)
 		err := g.Decode(&toBePurged)
+		if err != nil {
+			return err
+		}
 
+		if gobbed = b.Get([]byte("reminders")); gobbed != nil {
+			err = gob.NewDecoder(bytes.NewBuffer(gobbed)).Decode(&remindersSent)
+			if err != nil {
+				return err
+			}
+		}
+		if gobbed = b.Get([]byte("confirmations")); gobbed != nil {
+			err = gob.NewDecoder(bytes.NewBuffer(gobbed)).Decode(&confirmations)
+		}
 		return err
 	})
 	if err != nil {
 		log.Printf("Failed to load state: %s", err)
 	}
+
+	updateMemberIndex()
 }
 
 func exempt(m MemberTrackingMember) bool {
@@ -123,284 +233,439 @@ func exempt(m MemberTrackingMember) bool {
 		}
 	}
 
-	for _, ship := range exemptHulls {
-		if m.ShipType == ship {
-			return true
-		}
+	if exemptShips.exempt(m, shipTypes) {
+		return true
+	}
+
+	if exemptMembers.match(m) != "" {
+		return true
+	}
+
+	if _, ok := exemptedUntil(m); ok {
+		return true
 	}
 	return false
 }
 
 type SQLCorpMemberTracker struct {
-	db         *sql.DB
-	singleStmt *sql.Stmt
-	allStmt    *sql.Stmt
+	db          *sql.DB
+	allQuery    string
+	singleQuery string
+	singleStmt  *sql.Stmt
+	allStmt     *sql.Stmt
 
 	sync.RWMutex
 }
 
-func NewSQLCorpMemberTracker(db *sql.DB) *SQLCorpMemberTracker {
+func NewSQLCorpMemberTracker(db *sql.DB, allQuery, singleQuery string) *SQLCorpMemberTracker {
 	s := SQLCorpMemberTracker{}
 	s.db = db
+	s.allQuery = allQuery
+	s.singleQuery = singleQuery
 
 	return &s
 }
 
-func (s *SQLCorpMemberTracker) IsRegistered(charName string) bool {
+// prepare prepares query into stmt if it isn't already.
+func (s *SQLCorpMemberTracker) prepare(stmt **sql.Stmt, query string) error {
+	if *stmt != nil {
+		return nil
+	}
+	if query == "" {
+		return errors.New("registered_characters dsn specified but no query found")
+	}
+
 	var err error
+	*stmt, err = s.db.Prepare(query)
+	if err != nil {
+		return fmt.Errorf("failed to prepare registered character query '%s': %s", query, err)
+	}
+	return nil
+}
+
+// reset drops a statement after a failure so it's prepared again, possibly
+// on a new connection, next time.
+func (s *SQLCorpMemberTracker) reset(stmt **sql.Stmt) {
+	if *stmt != nil {
+		(*stmt).Close()
+		*stmt = nil
+	}
+}
 
+func (s *SQLCorpMemberTracker) IsRegistered(charName string) (bool, error) {
 	s.Lock()
 	defer s.Unlock()
 
-	if s.singleStmt == nil {
-		query, _ := conf.String("registered_characters", "single_query")
-		if query == "" {
-			log.Fatalf("registered_characters dsn specified but no query found.")
-		}
-
-		s.singleStmt, err = s.db.Prepare(query)
-		if err != nil {
-			log.Fatalf("failed to prepare registered character query '%s': %s", query, err)
-			return false
-		}
+	err := s.prepare(&s.singleStmt, s.singleQuery)
+	if err != nil {
+		return false, err
 	}
 
 	var name string
 	err = s.singleStmt.QueryRow(charName).Scan(&name)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
 	if err != nil {
-		if err != sql.ErrNoRows {
-			log.Printf("isRegisteredChar error: %s", err)
-		}
-		return false
+		s.reset(&s.singleStmt)
+		return false, fmt.Errorf("isRegisteredChar error: %s", err)
 	}
 
 	if name == "" {
 		log.Printf("got empty name for %s?", charName)
-		return false
+		return false, nil
 	}
 
-	if name != charName {
+	if normalizeName(name) != normalizeName(charName) {
 		log.Printf("unexpected charName for isRegisteredChar (%s/%s)", name, charName)
-		return false
+		return false, nil
 	}
 
-	return true
+	return true, nil
 }
 
-func (s *SQLCorpMemberTracker) GetMemberMap() (map[string]bool, error) {
-	var err error
-
+// GetRegistrations runs all_query, which may return character names,
+// character IDs and names, or character IDs, names and the name of each
+// character's main.
+func (s *SQLCorpMemberTracker) GetRegistrations() (*Registrations, error) {
 	s.Lock()
 	defer s.Unlock()
 
-	if s.allStmt == nil {
-		query, _ := conf.String("registered_characters", "all_query")
-		if query == "" {
-			log.Fatalf("registered_characters dsn specified but no query found.")
-		}
-
-		s.allStmt, err = s.db.Prepare(query)
-		if err != nil {
-			return nil, fmt.Errorf("failed to prepare registered character query '%s': %s", query, err)
-		}
+	err := s.prepare(&s.allStmt, s.allQuery)
+	if err != nil {
+		return nil, err
 	}
 
-	registeredChars := make(map[string]bool)
 	rows, err := s.allStmt.Query()
+	if err != nil {
+		s.reset(&s.allStmt)
+		return nil, fmt.Errorf("failed registered character query: %s", err)
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
 	if err != nil {
 		return nil, fmt.Errorf("failed registered character query: %s", err)
 	}
+	withIDs := len(cols) > 1
+	registeredChars := newRegistrations(withIDs)
 
+	var charID sql.NullInt64
 	var charName string
+	var mainName sql.NullString
 	for rows.Next() {
-		err := rows.Scan(&charName)
+		if len(cols) > 2 {
+			err = rows.Scan(&charID, &charName, &mainName)
+		} else if withIDs {
+			err = rows.Scan(&charID, &charName)
+		} else {
+			err = rows.Scan(&charName)
+		}
 		if err != nil {
 			return nil, fmt.Errorf("failed scanning: %s", err)
 		}
 
-		registeredChars[strings.ToLower(charName)] = true
+		registeredChars.add(charID.Int64, charName)
+		registeredChars.addMain(charName, mainName.String)
+	}
+	if err := rows.Err(); err != nil {
+		s.reset(&s.allStmt)
+		return nil, fmt.Errorf("failed reading registered characters: %s", err)
 	}
 
 	return registeredChars, nil
 }
 
+func (s *SQLCorpMemberTracker) GetMemberMap() (map[string]bool, error) {
+	reg, err := s.GetRegistrations()
+	if err != nil {
+		return nil, err
+	}
+	return reg.memberMap(), nil
+}
+
+// CorpMemberTracker checks characters against an external registration
+// system.  IsRegistered returns an error if it can't tell either way.
 type CorpMemberTracker interface {
 	GetMemberMap() (map[string]bool, error)
-	IsRegistered(name string) bool
+	IsRegistered(name string) (bool, error)
 }
 
-func membersUpdater(apiClient *apicache.Client, keyid int64, vcode string, maxIdle time.Duration, cmt CorpMemberTracker) {
-	var registeredChars map[string]bool
-	var err error
+// sleepCtx waits for d to pass, returning false if ctx was cancelled first.
+func sleepCtx(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
 
-	memberReq := apiClient.NewRequest("/corp/MemberTracking.xml.aspx")
-	memberReq.Set("keyid", fmt.Sprintf("%d", keyid))
-	memberReq.Set("vcode", vcode)
-	memberReq.Set("extended", "1")
+// lastPull holds the most recent roster so policy changes can be applied
+// without waiting for the next API pull.  Protected by purgeLock.
+var lastPull struct {
+	members    []MemberTrackingMember
+	registered *Registrations
+	cmt        CorpMemberTracker
+	pulled     time.Time
+
+	// staleRoles is set when member security couldn't be fetched and role
+	// sets were carried over from the pull before, so roles can't be
+	// trusted to show strips.
+	staleRoles bool
+}
 
-	for {
-		if cmt != nil {
-			registeredChars, err = cmt.GetMemberMap()
-			if err != nil {
-				log.Printf("Error getting registered characters: %s", err)
-			}
+// registrationMismatches lists members whose registration only partially
+// matched, such as renamed characters.  Protected by purgeLock.
+var registrationMismatches []string
+
+// buildPurgeList evaluates members against the current policy, carrying over
+// claim and strip times from toBePurged.  Must be called with purgeLock held.
+// A nil registeredChars with a tracker configured means registrations
+// couldn't be fetched, in which case previously unregistered members are
+// kept with their registration marked unknown.
+func buildPurgeList(members []MemberTrackingMember, registeredChars *Registrations, cmt CorpMemberTracker) map[int64]*purgeMember {
+	var newPurge = map[int64]*purgeMember{}
+	var mismatches []string
+	var pending []pendingMember
+	unknown := cmt != nil && registeredChars == nil
+	lapseExemptions()
+
+	idleReasons := map[int64]string{}
+	idleMembers := map[int64]bool{}
+	for _, mt := range members {
+		idleReasons[mt.CharacterID] = idleReason(mt)
+		idleMembers[mt.CharacterID] = idleReasons[mt.CharacterID] != ""
+	}
+	groups := groupMembers(members, registeredChars, idleMembers)
+
+	for _, mt := range members {
+		registered, mismatch, source := true, "", ""
+		if !unknown {
+			registered, mismatch, source = registeredChars.match(mt)
+		}
+		if mismatch != "" {
+			mismatches = append(mismatches, mismatch)
 		}
 
-		log.Printf("Pulling current corp member list.")
-		resp, err := memberReq.Do()
-		if err != nil {
-			log.Printf("API Error: %s", err)
-			time.Sleep(60 * time.Second)
-			continue
+		// New joiners get some time to register before it counts.
+		if !registered {
+			if days := graceDaysLeft(mt); days > 0 && !exempt(mt) {
+				pending = append(pending, pendingMember{mt.Name, mt.CharacterID,
+					mt.StartDateTime.Time, days})
+				registered = true
+			}
 		}
 
-		type MemberTracking struct {
-			Members []MemberTrackingMember `xml:"result>rowset>row"`
+		// An idle alt of an active main, or the reverse, is left alone.
+		idle, idleWhy := idleMembers[mt.CharacterID], idleReasons[mt.CharacterID]
+		if idle && groups.activeAlts(mt) {
+			idle, idleWhy = false, ""
 		}
 
-		var members MemberTracking
-		err = xml.Unmarshal(resp.Data, &members)
-		if err != nil {
-			log.Printf("API Error: %s", err)
-			time.Sleep(60 * time.Second)
-			continue
+		wasUnregistered := false
+		if oldm, ok := toBePurged[mt.CharacterID]; ok {
+			wasUnregistered = oldm.Unregistered || oldm.RegistrationUnknown
 		}
 
-		purgeLock.Lock()
-		var newPurge = map[int64]*purgeMember{}
-		var registered bool
-		for _, mt := range members.Members {
-			if registeredChars == nil {
-				registered = true
-			} else {
-				_, registered = registeredChars[strings.ToLower(mt.Name)]
-			}
+		target := targetMembers.match(mt)
+		manual := manualTargetFor(mt)
 
-			if time.Since(mt.LogonDateTime.Time) > maxIdle || !registered {
-				if exempt(mt) {
-					continue
-				}
+		// A manual entry is a deliberate decision and overrides exemptions.
+		if idle || !registered || (unknown && wasUnregistered) || target != "" || manual != nil {
+			if exempt(mt) && manual == nil {
+				continue
+			}
 
-				var m purgeMember
+			var m purgeMember
 
-				m = purgeMember{mt.Name, mt.CharacterID,
-					mt.StartDateTime.Time, mt.LogonDateTime.Time,
-					mt.ShipType, false, time.Time{}, time.Time{}, false, "", nil}
+			m = purgeMember{Name: mt.Name, Id: mt.CharacterID,
+				Joined: mt.StartDateTime.Time, LastLogin: mt.LogonDateTime.Time,
+				ShipType: mt.ShipType, Title: mt.Title, Base: mt.Base,
+				Location: mt.Location, RegisteredBy: source, Idle: idle,
+				Targeted: target != "", Manual: manual != nil,
+				RegistrationUnknown: unknown}
 
-				if mt.Roles != 0 || mt.GrantableRoles != 0 {
-					m.Roles = true
-				}
+			m.RoleSets = memberRoles(mt)
+			m.Roles = m.RoleSets.any()
 
-				// Persist strip times and claim times
-				if oldm, ok := toBePurged[mt.CharacterID]; ok {
-					if !oldm.Stripped.IsZero() && !m.Roles {
-						m.Stripped = oldm.Stripped
-					}
-					if !oldm.Claimed.IsZero() {
-						m.Claimed = oldm.Claimed
-					}
-					if oldm.Roles == true && m.Roles == false {
-						m.Stripped = time.Now()
-					}
-				}
+			// Persist strip times and claim times
+			if oldm, ok := toBePurged[mt.CharacterID]; ok {
+				m.carryStrip(oldm)
+			}
 
-				if time.Since(mt.LogonDateTime.Time) > maxIdle {
-					m.Reason += fmt.Sprintf("Idle %s days. ", daysSince(mt.LogonDateTime.Time))
-				}
-				if !registered {
-					m.Reason += "Unregistered."
-					regName := m.Name
-					m.IsRegistered = func() bool {
-						return cmt.IsRegistered(regName)
-					}
-				}
-				if m.Reason == "" {
-					log.Printf("I'm supposed to kick %s but I don't know why.\n%#v", m.Name, m)
-					continue
+			if manual != nil {
+				m.Reason += fmt.Sprintf("Manual: %s. ", strings.TrimSuffix(manual.Reason, "."))
+				m.Priority = manual.Priority
+			}
+			if target != "" {
+				m.Reason += fmt.Sprintf("Targeted by %s. ", target)
+			}
+			m.Reason += idleWhy
+			if unknown {
+				m.Reason += "Registration unknown."
+			} else if !registered {
+				m.Reason += "Unregistered."
+				m.Unregistered = true
+				regName := m.Name
+				m.IsRegistered = func() (bool, error) {
+					return cmt.IsRegistered(regName)
 				}
-
-				newPurge[mt.CharacterID] = &m
 			}
+			if m.Reason == "" {
+				log.Printf("I'm supposed to kick %s but I don't know why.\n%#v", m.Name, m)
+				continue
+			}
+
+			newPurge[mt.CharacterID] = &m
 		}
+	}
 
-		toBePurged = newPurge
-		purgeLock.Unlock()
+	queueAlts(newPurge, members, groups)
 
-		go saveState()
+	logNewMismatches(registrationMismatches, mismatches)
+	registrationMismatches = mismatches
+	updatePending(pending, unknown)
 
-		log.Printf("Done. Next pull at %s", resp.Expires.Format(ApiDateTimeFormat))
-		select {
-		case <-time.After(resp.Expires.Sub(time.Now()) + 30*time.Second):
+	return newPurge
+}
+
+// logNewMismatches logs registration mismatches not seen on the last pass.
+func logNewMismatches(old, cur []string) {
+	seen := make(map[string]bool, len(old))
+	for _, m := range old {
+		seen[m] = true
+	}
+	for _, m := range cur {
+		if !seen[m] {
+			log.Printf("Registration mismatch: %s", m)
 		}
 	}
 }
 
-type HTTPCorpMemberTracker struct {
-	url         string
-	lastUpdate  time.Time
-	cachedNames map[string]bool
-
-	sync.RWMutex
+// diffPurgeLists returns the members present in newPurge but not oldPurge,
+// and those present in oldPurge but not newPurge.
+func diffPurgeLists(oldPurge, newPurge map[int64]*purgeMember) (added, removed []*purgeMember) {
+	for id, m := range newPurge {
+		if _, ok := oldPurge[id]; !ok {
+			added = append(added, m)
+		}
+	}
+	for id, m := range oldPurge {
+		if _, ok := newPurge[id]; !ok {
+			removed = append(removed, m)
+		}
+	}
+	return added, removed
 }
 
-func NewHTTPCorpMemberTracker(url string) *HTTPCorpMemberTracker {
-	var n HTTPCorpMemberTracker
-	n.url = url
-
-	n.update()
+// reevaluateRoster applies the current policy to the last pulled roster,
+// logging who was added to or removed from the queue as a result.
+func reevaluateRoster() (added, removed []*purgeMember) {
+	purgeLock.Lock()
+	defer purgeLock.Unlock()
 
-	return &n
-}
+	if lastPull.members == nil {
+		log.Printf("No roster pulled yet, policy will apply at the next pull.")
+		return nil, nil
+	}
 
-func (hcmt *HTTPCorpMemberTracker) update() {
-	hcmt.Lock()
-	defer hcmt.Unlock()
+	newPurge := buildPurgeList(lastPull.members, lastPull.registered, lastPull.cmt)
 
-	if time.Since(hcmt.lastUpdate) < time.Hour {
-		return
+	// The roster is the same stale one, so kicks confirmed since it was
+	// pulled still stand.  Only a fresh pull can show they didn't take.
+	for id, m := range newPurge {
+		if oldm, ok := toBePurged[id]; ok && oldm.Purged {
+			m.Purged = true
+		}
 	}
 
-	resp, err := http.Get(hcmt.url)
-	if err != nil {
-		log.Printf("Error getting registered member list: %s", err)
-		return
-	}
-	defer resp.Body.Close()
+	added, removed = diffPurgeLists(toBePurged, newPurge)
+	toBePurged = newPurge
+	queueSave()
 
-	newNames := map[string]bool{}
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		newNames[strings.ToLower(scanner.Text())] = true
+	log.Printf("Policy change added %d and removed %d queued members.", len(added), len(removed))
+	for _, m := range added {
+		log.Printf("+ %s: %s", m.Name, m.Reason)
 	}
-	if err := scanner.Err(); err != nil {
-		log.Printf("Error reading registered member list: %s", err)
-		return
+	for _, m := range removed {
+		log.Printf("- %s: %s", m.Name, m.Reason)
 	}
 
-	hcmt.cachedNames = newNames
-	hcmt.lastUpdate = time.Now()
+	return added, removed
 }
 
-func (hcmt *HTTPCorpMemberTracker) GetMemberMap() (map[string]bool, error) {
-	hcmt.update()
+func membersUpdater(ctx context.Context, apiClient *apicache.Client, keyid int64, vcode string, cmt CorpMemberTracker) {
+	var registeredChars *Registrations
+	var err error
 
-	hcmt.RLock()
-	defer hcmt.RUnlock()
+	memberReq := apiClient.NewRequest("/corp/MemberTracking.xml.aspx")
+	memberReq.Set("keyid", fmt.Sprintf("%d", keyid))
+	memberReq.Set("vcode", vcode)
+	memberReq.Set("extended", "1")
 
-	retMap := map[string]bool{}
-	for k, v := range hcmt.cachedNames {
-		retMap[k] = v
-	}
+	securityReq := apiClient.NewRequest("/corp/MemberSecurity.xml.aspx")
+	securityReq.Set("keyid", fmt.Sprintf("%d", keyid))
+	securityReq.Set("vcode", vcode)
 
-	return retMap, nil
-}
+	for ctx.Err() == nil {
+		if cmt != nil {
+			// Without a fresh list registration status is unknown, rather
+			// than trusting a stale one.
+			registeredChars, err = getRegistrations(cmt)
+			if err != nil {
+				log.Printf("Error getting registered characters, registration status unknown: %s", err)
+			}
+		}
+
+		log.Printf("Pulling current corp member list.")
+		resp, err := memberReq.Do()
+		if err != nil {
+			log.Printf("API Error: %s", err)
+			sleepCtx(ctx, 60*time.Second)
+			continue
+		}
+		pulled := time.Now()
 
-func (hcmt *HTTPCorpMemberTracker) IsRegistered(name string) bool {
-	hcmt.update()
+		type MemberTracking struct {
+			Members []MemberTrackingMember `xml:"result>rowset>row"`
+		}
 
-	hcmt.RLock()
-	defer hcmt.RUnlock()
+		var members MemberTracking
+		err = xml.Unmarshal(resp.Data, &members)
+		if err != nil {
+			log.Printf("API Error: %s", err)
+			sleepCtx(ctx, 60*time.Second)
+			continue
+		}
+
+		// Full role sets need member security access, without it only
+		// general and grantable roles are known.
+		security, secErr := fetchRoleSets(securityReq)
+		if secErr != nil {
+			log.Printf("Couldn't get member security, using the last known roles: %s", secErr)
+		}
+		for i, mt := range members.Members {
+			if r, ok := security[mt.CharacterID]; ok {
+				members.Members[i].Security = &r
+			}
+		}
 
-	_, ok := hcmt.cachedNames[name]
-	return ok
+		purgeLock.Lock()
+		staleRoles := secErr != nil && reuseRoleSets(members.Members)
+		lastPull.staleRoles = staleRoles
+		lastPull.members = members.Members
+		lastPull.registered = registeredChars
+		lastPull.cmt = cmt
+		lastPull.pulled = pulled
+		toBePurged = buildPurgeList(members.Members, registeredChars, cmt)
+		purgeLock.Unlock()
+
+		queueSave()
+		saveSnapshot(pulled, members.Members, staleRoles)
+
+		log.Printf("Done. Next pull at %s", resp.Expires.Format(ApiDateTimeFormat))
+		sleepCtx(ctx, resp.Expires.Sub(time.Now())+30*time.Second)
+	}
+	log.Printf("Member updater stopped.")
 }
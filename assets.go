@@ -0,0 +1,103 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+)
+
+//go:embed templates static
+var embeddedAssets embed.FS
+
+// assets is where templates and static files are served from: the embedded
+// copy, or the working directory in dev mode, with any theme dir layered on
+// top.
+var assets fs.FS = embeddedAssets
+
+// devMode reparses templates from disk on every request.
+var devMode bool
+
+var pageNames = []string{"login", "root", "strip", "boot", "pending", "exemptions", "audit", "history", "analytics", "import", "member", "members"}
+var pageTemplates = map[string]*template.Template{}
+var staticHandler http.Handler
+
+// layeredFS serves each file from the first layer that has it, so a theme
+// only needs to contain the files it changes.
+type layeredFS []fs.FS
+
+func (l layeredFS) Open(name string) (fs.File, error) {
+	for _, layer := range l {
+		f, err := layer.Open(name)
+		if err == nil {
+			return f, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// setupAssets picks where assets come from and parses every page template,
+// failing early on any template error.
+func setupAssets(themeDir string, dev bool) error {
+	var base fs.FS = embeddedAssets
+	if dev {
+		base = os.DirFS(".")
+	}
+
+	if themeDir != "" {
+		assets = layeredFS{os.DirFS(themeDir), base}
+	} else {
+		assets = base
+	}
+	devMode = dev
+
+	for _, name := range pageNames {
+		t, err := parsePage(name)
+		if err != nil {
+			return err
+		}
+		pageTemplates[name] = t
+	}
+
+	static, err := fs.Sub(assets, "static")
+	if err != nil {
+		return err
+	}
+	staticHandler = http.StripPrefix("/static/", http.FileServer(http.FS(static)))
+
+	return nil
+}
+
+func parsePage(name string) (*template.Template, error) {
+	return template.New("base.html").Funcs(tFuncMap).ParseFS(assets, "templates/base.html", "templates/"+name+".html")
+}
+
+// renderPage executes the named page template, reparsing it first in dev
+// mode.
+func renderPage(w io.Writer, name string, data interface{}) {
+	t := pageTemplates[name]
+	if devMode {
+		var err error
+		t, err = parsePage(name)
+		if err != nil {
+			log.Printf("Template error: %s", err)
+			return
+		}
+	}
+
+	err := t.Execute(w, data)
+	if err != nil {
+		log.Printf("Template Error: %s", err)
+	}
+}
+
+func handleStatic(w http.ResponseWriter, r *http.Request) {
+	staticHandler.ServeHTTP(w, r)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// auditEvent records something an operator, or slopemaker itself, did.
+type auditEvent struct {
+	Time        time.Time
+	User        string
+	Action      string
+	CharacterID int64
+	Name        string
+	Detail      string
+}
+
+// recordAudit appends an event to the audit log.
+func recordAudit(user, action string, id int64, name, detail string) {
+	e := auditEvent{time.Now(), user, action, id, name, detail}
+
+	err := bdb.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("audit"))
+		if err != nil {
+			return err
+		}
+
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, seq)
+
+		buf := &bytes.Buffer{}
+		err = gob.NewEncoder(buf).Encode(e)
+		if err != nil {
+			return err
+		}
+		return b.Put(key, buf.Bytes())
+	})
+	if err != nil {
+		log.Printf("Failed to record audit event %s %s: %s", action, name, err)
+	}
+}
+
+// readAudit returns up to limit events, newest first, for which match
+// returns true.  A nil match accepts every event.
+func readAudit(limit int, match func(e *auditEvent) bool) []auditEvent {
+	var events []auditEvent
+
+	err := bdb.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("audit"))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil && len(events) < limit; k, v = c.Prev() {
+			var e auditEvent
+			err := gob.NewDecoder(bytes.NewReader(v)).Decode(&e)
+			if err != nil {
+				return err
+			}
+			if match == nil || match(&e) {
+				events = append(events, e)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to read audit log: %s", err)
+	}
+
+	return events
+}
+
+func handleAudit(w http.ResponseWriter, r *http.Request) {
+	type AuditData struct {
+		Title  string
+		Events []auditEvent
+	}
+	ad := AuditData{Title: "Audit Log", Events: readAudit(500, nil)}
+
+	renderPage(w, "audit", ad)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/robfig/config"
+)
+
+// Exit codes for check-config, suitable for deployment pipelines.
+const (
+	checkOK       = 0
+	checkProblems = 1
+	checkUnusable = 2
+)
+
+// checkConfig implements the check-config subcommand.  It validates every key
+// in the config file, then tries the external services it refers to, printing
+// every problem found before exiting.
+func checkConfig(args []string) int {
+	fs := flag.NewFlagSet("check-config", flag.ContinueOnError)
+	standIn := fs.String("registered-url", "", "probe this URL instead of any registration backend URL")
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout for each connectivity check")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s check-config [flags] [config file]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return checkUnusable
+	}
+
+	file := configFile
+	if fs.NArg() > 0 {
+		file = fs.Arg(0)
+	}
+
+	c, err := readConfig(file)
+	if err != nil {
+		fmt.Printf("Unable to read %s: %s\n", file, err)
+		return checkUnusable
+	}
+
+	cerr := &ConfigError{File: file}
+	_, err = validateConfig(file, c)
+	if verr, ok := err.(*ConfigError); ok {
+		cerr.Problems = append(cerr.Problems, verr.Problems...)
+	}
+
+	dbfile, _ := c.String("purger", "boltDB")
+	if dbfile != "" {
+		dir := filepath.Dir(dbfile)
+		if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+			cerr.add("[purger] boltDB directory '%s' does not exist", dir)
+		}
+	}
+
+	_, sections := trackerSections(c)
+	for _, section := range sections {
+		memberDSN, _ := c.String(section, "DSN")
+		if memberDSN != "" {
+			checkDSN(cerr, c, section, memberDSN, *timeout)
+		}
+
+		memberURL, _ := c.String(section, "URL")
+		if memberURL != "" && *standIn != "" {
+			memberURL = *standIn
+		}
+		if memberURL != "" && validURL(memberURL) {
+			opts, _ := httpTrackerOptions(c, section)
+			checkURL(cerr, section, memberURL, opts.Headers, *timeout)
+		}
+
+		memberLDAP, _ := c.String(section, "LDAP")
+		if memberLDAP != "" {
+			opts, err := ldapTrackerOptions(c, section)
+			if err == nil {
+				checkLDAP(cerr, section, memberLDAP, opts)
+			}
+		}
+	}
+
+	if list, _ := c.String("purger", "admins"); len(splitList(list)) == 0 {
+		fmt.Println("Note: [purger] admins is unset, admin functions are disabled.")
+	}
+
+	if len(cerr.Problems) > 0 {
+		fmt.Println(cerr)
+		return checkProblems
+	}
+
+	fmt.Printf("%s OK\n", file)
+	return checkOK
+}
+
+// checkDSN connects to the registered character database and prepares each
+// configured query to catch syntax errors.
+func checkDSN(cerr *ConfigError, c *config.Config, section, dsn string, timeout time.Duration) {
+	setting, _ := c.String(section, "driver")
+	driver, err := sqlDriver(setting)
+	if err != nil {
+		return
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		cerr.add("[%s] DSN could not be opened: %s", section, err)
+		return
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err = db.PingContext(ctx)
+	if err != nil {
+		cerr.add("[%s] could not connect to DSN: %s", section, err)
+		return
+	}
+
+	for _, q := range []string{"all_query", "single_query"} {
+		query, _ := c.String(section, q)
+		if query == "" {
+			continue
+		}
+
+		stmt, err := db.PrepareContext(ctx, rebind(driver, query))
+		if err != nil {
+			cerr.add("[%s] %s failed to prepare: %s", section, q, err)
+			continue
+		}
+		stmt.Close()
+	}
+}
+
+// checkURL makes sure the registered character list can be fetched.
+func checkURL(cerr *ConfigError, section, u string, headers http.Header, timeout time.Duration) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		cerr.add("[%s] URL '%s' is invalid: %s", section, u, err)
+		return
+	}
+	for k, v := range headers {
+		req.Header[k] = v
+	}
+
+	client := http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		cerr.add("[%s] URL '%s' is unreachable: %s", section, u, err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		cerr.add("[%s] URL '%s' returned %s", section, u, resp.Status)
+	}
+}
+
+// checkLDAP binds to the directory and runs the configured search.
+func checkLDAP(cerr *ConfigError, section, u string, opts LDAPTrackerOptions) {
+	l := newLDAPTracker(u, opts)
+	reg, err := l.search(l.opts.Filter)
+	if err != nil {
+		cerr.add("[%s] LDAP '%s' failed: %s", section, u, err)
+		return
+	}
+	if len(reg.Names) == 0 {
+		cerr.add("[%s] LDAP search found no characters", section)
+	}
+}
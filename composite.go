@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+type compositeBackend struct {
+	name    string
+	tracker CorpMemberTracker
+	timeout time.Duration
+}
+
+// CompositeCorpMemberTracker checks several trackers at once.  By default a
+// character registered with any backend counts as registered, with
+// requireAll it must be registered with every one of them.
+type CompositeCorpMemberTracker struct {
+	backends   []compositeBackend
+	requireAll bool
+}
+
+func NewCompositeCorpMemberTracker(requireAll bool) *CompositeCorpMemberTracker {
+	return &CompositeCorpMemberTracker{requireAll: requireAll}
+}
+
+// Add includes a backend, which is given up on if it takes longer than
+// timeout to answer.
+func (c *CompositeCorpMemberTracker) Add(name string, t CorpMemberTracker, timeout time.Duration) {
+	c.backends = append(c.backends, compositeBackend{name, t, timeout})
+}
+
+// GetRegistrations queries every backend concurrently.  If any backend fails
+// or times out the whole lookup fails, as a partial list would wrongly mark
+// members unregistered.
+func (c *CompositeCorpMemberTracker) GetRegistrations() (*Registrations, error) {
+	type result struct {
+		reg *Registrations
+		err error
+	}
+
+	start := time.Now()
+	results := make([]chan result, len(c.backends))
+	for i, b := range c.backends {
+		results[i] = make(chan result, 1)
+		go func(b compositeBackend, ch chan result) {
+			reg, err := getRegistrations(b.tracker)
+			ch <- result{reg, err}
+		}(b, results[i])
+	}
+
+	combined := &Registrations{requireAll: c.requireAll}
+	for i, b := range c.backends {
+		select {
+		case r := <-results[i]:
+			if r.err != nil {
+				return nil, fmt.Errorf("backend %s: %s", b.name, r.err)
+			}
+			combined.parts = append(combined.parts, namedRegistrations{b.name, r.reg})
+		case <-time.After(b.timeout - time.Since(start)):
+			return nil, fmt.Errorf("backend %s timed out after %s", b.name, b.timeout)
+		}
+	}
+
+	return combined, nil
+}
+
+func (c *CompositeCorpMemberTracker) GetMemberMap() (map[string]bool, error) {
+	reg, err := c.GetRegistrations()
+	if err != nil {
+		return nil, err
+	}
+	return reg.memberMap(), nil
+}
+
+// IsRegistered asks every backend.  An answer is only given if the
+// backends that responded settle it regardless of those that didn't.
+func (c *CompositeCorpMemberTracker) IsRegistered(name string) (bool, error) {
+	type result struct {
+		ok  bool
+		err error
+	}
+
+	start := time.Now()
+	results := make([]chan result, len(c.backends))
+	for i, b := range c.backends {
+		results[i] = make(chan result, 1)
+		go func(b compositeBackend, ch chan result) {
+			ok, err := b.tracker.IsRegistered(name)
+			ch <- result{ok, err}
+		}(b, results[i])
+	}
+
+	var vouched, refused int
+	var lastErr error
+	for i, b := range c.backends {
+		select {
+		case r := <-results[i]:
+			if r.err != nil {
+				lastErr = fmt.Errorf("backend %s: %s", b.name, r.err)
+			} else if r.ok {
+				vouched++
+			} else {
+				refused++
+			}
+		case <-time.After(b.timeout - time.Since(start)):
+			lastErr = fmt.Errorf("backend %s timed out after %s", b.name, b.timeout)
+		}
+	}
+
+	if c.requireAll {
+		if refused > 0 {
+			return false, nil
+		}
+		if vouched == len(c.backends) {
+			return true, nil
+		}
+	} else {
+		if vouched > 0 {
+			return true, nil
+		}
+		if refused == len(c.backends) {
+			return false, nil
+		}
+	}
+	return false, lastErr
+}
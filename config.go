@@ -0,0 +1,469 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/config"
+)
+
+// configFile is the config path, set by -config or SLOPEMAKER_CONFIG.
+var configFile = "purger.conf"
+
+// setFlags holds section.key=value overrides given with -set.
+var setFlags []string
+
+const envPrefix = "SLOPEMAKER_"
+
+var conf *config.Config
+var exemptRoles []int64
+var exemptChars []string
+var maxIdle time.Duration
+var idleLimits idleTiers
+var admins []string
+var registrationGrace time.Duration
+var reminderURL string
+var reminderDays []int
+var shipTypes *shipTable
+var exemptShips *shipExemptions
+var exemptMembers memberFilter
+var targetMembers memberFilter
+
+// configLock serialises reloads so SIGHUP and the admin endpoint can't race.
+var configLock sync.Mutex
+
+// ConfigError reports every problem found while validating a config file.
+type ConfigError struct {
+	File     string
+	Problems []string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("%s has %d problem(s):\n\t%s", e.File, len(e.Problems),
+		strings.Join(e.Problems, "\n\t"))
+}
+
+func (e *ConfigError) add(format string, args ...interface{}) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+// splitList breaks a comma separated config value into trimmed, non-empty
+// entries.
+func splitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+func validURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// knownOptions maps each section to its options so that environment
+// variables, which are upper case, can be matched back to the option name.
+var knownOptions = map[string][]string{
+	"purger": {"listen", "keyid", "vcode", "maxIdleDays", "maxIdleDaysRoles",
+		"tenureDays", "maxIdleDaysTenured", "maxIdleDaysNeverLoggedOn", "boltDB",
+		"APIBaseURL", "exemptCharacters", "exemptRoles", "admins", "themeDir", "devMode",
+		"registrationGraceDays", "reminderURL", "reminderDays", "shipTypesFile",
+		"exemptShipTypes", "exemptShipGroups", "exemptShipCategories",
+		"exemptTitles", "exemptBases", "exemptLocations",
+		"targetTitles", "targetBases", "targetLocations", "historyDays"},
+	"registered_characters": {"URL", "DSN", "all_query", "single_query",
+		"backends", "mode", "timeout", "format", "json_path", "json_name",
+		"json_id", "json_main", "refresh", "headers", "bearer_token", "basic_auth", "LDAP",
+		"bind_dn", "bind_password", "start_tls", "base_dn", "filter",
+		"name_attr", "id_attr", "driver"},
+	"auth": {},
+}
+
+// optionName finds the proper spelling of an option given in any case,
+// falling back to lower case for options we don't know about such as
+// usernames in [auth].
+func optionName(c *config.Config, section, option string) string {
+	known := knownOptions[section]
+	if strings.HasPrefix(section, "registered_characters_") {
+		known = knownOptions["registered_characters"]
+	}
+	for _, o := range known {
+		if strings.EqualFold(o, option) {
+			return o
+		}
+	}
+	if opts, err := c.Options(section); err == nil {
+		for _, o := range opts {
+			if strings.EqualFold(o, option) {
+				return o
+			}
+		}
+	}
+	return strings.ToLower(option)
+}
+
+func setOption(c *config.Config, section, option, value string) {
+	if !c.HasSection(section) {
+		c.AddSection(section)
+	}
+	c.AddOption(section, optionName(c, section, option), value)
+}
+
+// readSecret reads a value from a file, as used by docker and kubernetes
+// secrets, dropping any trailing newline.
+func readSecret(file string) (string, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// applyEnv overrides options from SLOPEMAKER_SECTION_KEY environment
+// variables.  SLOPEMAKER_SECTION_KEY_FILE reads the value from a file instead.
+func applyEnv(c *config.Config, environ []string) error {
+	var sections []string
+	for s := range knownOptions {
+		sections = append(sections, s)
+	}
+	sections = append(sections, c.Sections()...)
+
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, envPrefix) {
+			continue
+		}
+		eq := strings.Index(kv, "=")
+		if eq < 0 {
+			continue
+		}
+		name, value := kv[len(envPrefix):eq], kv[eq+1:]
+		if name == "CONFIG" {
+			continue
+		}
+
+		// Section names may contain underscores, so take the longest match.
+		var section string
+		for _, s := range sections {
+			if strings.HasPrefix(name, strings.ToUpper(s)+"_") && len(s) > len(section) {
+				section = s
+			}
+		}
+		if section == "" {
+			log.Printf("Ignoring %s%s, no such config section.", envPrefix, name)
+			continue
+		}
+		option := name[len(section)+1:]
+
+		if strings.HasSuffix(option, "_FILE") {
+			option = strings.TrimSuffix(option, "_FILE")
+			var err error
+			value, err = readSecret(value)
+			if err != nil {
+				return fmt.Errorf("reading %s%s: %s", envPrefix, name, err)
+			}
+		}
+
+		setOption(c, section, option, value)
+	}
+	return nil
+}
+
+// applySetFlags overrides options from -set section.key=value flags.  A value
+// of @path reads it from a file.
+func applySetFlags(c *config.Config, sets []string) error {
+	for _, set := range sets {
+		eq := strings.Index(set, "=")
+		dot := strings.Index(set, ".")
+		if eq < 0 || dot < 0 || dot > eq {
+			return fmt.Errorf("-set %s: expected section.key=value", set)
+		}
+		section, option, value := set[:dot], set[dot+1:eq], set[eq+1:]
+
+		if strings.HasPrefix(value, "@") {
+			var err error
+			value, err = readSecret(value[1:])
+			if err != nil {
+				return fmt.Errorf("-set %s: %s", set, err)
+			}
+		}
+
+		setOption(c, section, option, value)
+	}
+	return nil
+}
+
+// readConfig reads a config file and applies overrides.  Flags take
+// precedence over environment variables, which take precedence over the file.
+func readConfig(file string) (*config.Config, error) {
+	c, err := config.ReadDefault(file)
+	if err != nil {
+		return nil, err
+	}
+
+	err = applyEnv(c, os.Environ())
+	if err != nil {
+		return nil, err
+	}
+
+	err = applySetFlags(c, setFlags)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// policy is the part of the config that decides who ends up in the queue.
+type policy struct {
+	exemptChars []string
+	exemptRoles []int64
+	maxIdle     time.Duration
+	idleLimits  idleTiers
+	admins      []string
+
+	registrationGrace time.Duration
+	reminderURL       string
+	reminderDays      []int
+
+	shipTypes   *shipTable
+	exemptShips *shipExemptions
+
+	exemptMembers memberFilter
+	targetMembers memberFilter
+
+	historyRetention time.Duration
+}
+
+// validateConfig checks c for problems, returning the parsed policy if there
+// were none.  Every problem is collected rather than stopping at the first.
+func validateConfig(file string, c *config.Config) (*policy, error) {
+	cerr := &ConfigError{File: file}
+	p := &policy{}
+
+	keyid, err := c.String("purger", "keyid")
+	if err != nil || keyid == "" {
+		cerr.add("[purger] keyid is required")
+	} else if _, err := strconv.ParseInt(keyid, 10, 64); err != nil {
+		cerr.add("[purger] keyid '%s' is not a number", keyid)
+	}
+
+	vcode, err := c.String("purger", "vcode")
+	if err != nil || vcode == "" {
+		cerr.add("[purger] vcode is required")
+	}
+
+	days, err := c.String("purger", "maxIdleDays")
+	if err != nil || days == "" {
+		cerr.add("[purger] maxIdleDays is required")
+	} else if d, err := strconv.Atoi(days); err != nil || d <= 0 {
+		cerr.add("[purger] maxIdleDays '%s' must be a positive number of days", days)
+	} else {
+		p.maxIdle = time.Duration(d) * time.Hour * 24
+	}
+	p.idleLimits = parseIdleTiers(cerr, c)
+
+	listen, err := c.String("purger", "listen")
+	if err != nil || listen == "" {
+		cerr.add("[purger] listen is required")
+	} else if listen != "ENV" {
+		_, port, err := net.SplitHostPort(listen)
+		if err != nil {
+			cerr.add("[purger] listen '%s' is not a valid address: %s", listen, err)
+		} else if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			cerr.add("[purger] listen '%s' has an invalid port", listen)
+		}
+	}
+
+	themeDir, _ := c.String("purger", "themeDir")
+	if themeDir != "" {
+		if fi, err := os.Stat(themeDir); err != nil || !fi.IsDir() {
+			cerr.add("[purger] themeDir '%s' is not a directory", themeDir)
+		}
+	}
+
+	if c.HasOption("purger", "devMode") {
+		if _, err := c.Bool("purger", "devMode"); err != nil {
+			cerr.add("[purger] devMode must be true or false")
+		}
+	}
+
+	baseURL, _ := c.String("purger", "APIBaseURL")
+	if baseURL != "" && !validURL(baseURL) {
+		cerr.add("[purger] APIBaseURL '%s' is not a valid http(s) URL", baseURL)
+	}
+
+	dbfile, err := c.String("purger", "boltDB")
+	if err != nil || dbfile == "" {
+		cerr.add("[purger] boltDB is required")
+	}
+
+	confStr, err := c.String("purger", "exemptCharacters")
+	if err == nil {
+		p.exemptChars = splitList(strings.ToLower(confStr))
+	}
+
+	confStr, err = c.String("purger", "exemptRoles")
+	if err == nil {
+		for _, v := range splitList(confStr) {
+			role, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || role <= 0 {
+				cerr.add("[purger] exemptRoles entry '%s' is not a positive role bitmask", v)
+				continue
+			}
+			p.exemptRoles = append(p.exemptRoles, role)
+		}
+	}
+
+	shipFile, _ := c.String("purger", "shipTypesFile")
+	p.shipTypes, err = loadShipTable(shipFile)
+	if err != nil {
+		cerr.add("[purger] shipTypesFile '%s' could not be loaded: %s", shipFile, err)
+		p.shipTypes, _ = loadShipTable("")
+	}
+	p.exemptShips = parseShipExemptions(cerr, c, p.shipTypes)
+
+	p.exemptMembers = parseMemberFilter(c, "exempt")
+	p.targetMembers = parseMemberFilter(c, "target")
+
+	confStr, err = c.String("purger", "admins")
+	if err == nil {
+		p.admins = splitList(strings.ToLower(confStr))
+	}
+
+	confStr, _ = c.String("purger", "registrationGraceDays")
+	if confStr != "" {
+		d, err := strconv.Atoi(confStr)
+		if err != nil || d < 0 {
+			cerr.add("[purger] registrationGraceDays '%s' must be a number of days", confStr)
+		}
+		p.registrationGrace = time.Duration(d) * time.Hour * 24
+	}
+
+	p.reminderURL, _ = c.String("purger", "reminderURL")
+	if p.reminderURL != "" && !validURL(p.reminderURL) {
+		cerr.add("[purger] reminderURL '%s' is not a valid http(s) URL", p.reminderURL)
+	}
+
+	confStr, _ = c.String("purger", "reminderDays")
+	for _, v := range splitList(confStr) {
+		d, err := strconv.Atoi(v)
+		if err != nil || d < 0 {
+			cerr.add("[purger] reminderDays entry '%s' is not a number of days", v)
+			continue
+		}
+		p.reminderDays = append(p.reminderDays, d)
+	}
+	if p.reminderURL != "" && len(p.reminderDays) == 0 {
+		p.reminderDays = []int{3, 1}
+	}
+
+	p.historyRetention = defaultHistoryDays * 24 * time.Hour
+	if c.HasOption("purger", "historyDays") {
+		confStr, _ = c.String("purger", "historyDays")
+		d, err := strconv.Atoi(confStr)
+		if err != nil || d < 0 {
+			cerr.add("[purger] historyDays '%s' must be a number of days", confStr)
+		}
+		p.historyRetention = time.Duration(d) * 24 * time.Hour
+	}
+
+	validateTrackers(cerr, c)
+
+	if len(cerr.Problems) > 0 {
+		return nil, cerr
+	}
+	return p, nil
+}
+
+// loadConfig reads and validates the config file, only replacing the running
+// configuration if it is free of problems.
+func loadConfig() error {
+	newConf, err := readConfig(configFile)
+	if err != nil {
+		return err
+	}
+
+	p, err := validateConfig(configFile, newConf)
+	if err != nil {
+		return err
+	}
+
+	purgeLock.Lock()
+	conf = newConf
+	exemptChars = p.exemptChars
+	exemptRoles = p.exemptRoles
+	maxIdle = p.maxIdle
+	idleLimits = p.idleLimits
+	admins = p.admins
+	registrationGrace = p.registrationGrace
+	reminderURL = p.reminderURL
+	reminderDays = p.reminderDays
+	shipTypes = p.shipTypes
+	exemptShips = p.exemptShips
+	exemptMembers = p.exemptMembers
+	targetMembers = p.targetMembers
+	historyRetention = p.historyRetention
+	purgeLock.Unlock()
+
+	if len(exemptChars) == 0 {
+		log.Printf("No exempted characters found.")
+	}
+	if len(exemptRoles) == 0 {
+		log.Printf("No exempted roles found.")
+	}
+	if len(admins) == 0 {
+		log.Printf("No admins found, admin functions are disabled.")
+	}
+
+	return nil
+}
+
+// reloadConfig loads the config file and immediately applies the new policy
+// to the most recently pulled roster.
+func reloadConfig() (added, removed []*purgeMember, err error) {
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	err = loadConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	added, removed = reevaluateRoster()
+	return added, removed, nil
+}
+
+// isAdmin reports whether username may use administrative functions.  With
+// no admins configured nobody may.
+func isAdmin(username string) bool {
+	purgeLock.RLock()
+	defer purgeLock.RUnlock()
+
+	return isAdminLocked(username)
+}
+
+// isAdminLocked is isAdmin for callers already holding purgeLock.
+func isAdminLocked(username string) bool {
+	username = strings.ToLower(username)
+	for _, a := range admins {
+		if a == username {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// exemption temporarily keeps a character out of the queue, such as someone
+// deployed in real life.
+type exemption struct {
+	CharacterID int64
+	Name        string
+	Reason      string
+	Creator     string
+	Created     time.Time
+	Expires     time.Time
+}
+
+// exemptions are keyed by normalized character name.  Protected by
+// purgeLock.
+var exemptions = map[string]*exemption{}
+
+// dateFormat is how dates are entered in forms and query strings.
+const dateFormat = "2006-01-02"
+
+// exemptedUntil reports whether mt has an unexpired exemption.  Must be
+// called with purgeLock held.
+func exemptedUntil(mt MemberTrackingMember) (*exemption, bool) {
+	e, ok := exemptions[normalizeName(mt.Name)]
+	if !ok {
+		for _, ex := range exemptions {
+			if ex.CharacterID != 0 && ex.CharacterID == mt.CharacterID {
+				e, ok = ex, true
+				break
+			}
+		}
+	}
+	if !ok || time.Now().After(e.Expires) {
+		return nil, false
+	}
+	return e, true
+}
+
+// lapseExemptions removes expired exemptions so their characters are
+// evaluated again.  Must be called with purgeLock held.
+func lapseExemptions() {
+	for key, e := range exemptions {
+		if time.Now().After(e.Expires) {
+			log.Printf("Exemption for %s lapsed.", e.Name)
+			delete(exemptions, key)
+			deleteExemption(key)
+			recordAudit("slopemaker", "exemption lapsed", e.CharacterID, e.Name, e.Reason)
+		}
+	}
+}
+
+func saveExemption(key string, e *exemption) error {
+	return bdb.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("exemptions"))
+		if err != nil {
+			return err
+		}
+
+		buf := &bytes.Buffer{}
+		err = gob.NewEncoder(buf).Encode(e)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), buf.Bytes())
+	})
+}
+
+func deleteExemption(key string) {
+	err := bdb.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("exemptions"))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(key))
+	})
+	if err != nil {
+		log.Printf("Failed to delete exemption %s: %s", key, err)
+	}
+}
+
+func loadExemptions() {
+	purgeLock.Lock()
+	defer purgeLock.Unlock()
+
+	err := bdb.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("exemptions"))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var e exemption
+			err := gob.NewDecoder(bytes.NewReader(v)).Decode(&e)
+			if err != nil {
+				return err
+			}
+			exemptions[string(k)] = &e
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("Failed to load exemptions: %s", err)
+	}
+}
+
+// addExemption exempts the named character until expires.  Must be called
+// with purgeLock held.
+func addExemption(name, reason, creator string, expires time.Time) (*exemption, error) {
+	name = strings.TrimSpace(name)
+	reason = strings.TrimSpace(reason)
+	if name == "" {
+		return nil, errors.New("A character name is required.")
+	}
+	if reason == "" {
+		return nil, errors.New("A reason is required.")
+	}
+	if !expires.After(time.Now()) {
+		return nil, errors.New("The expiry date must be in the future.")
+	}
+
+	e := &exemption{Name: name, Reason: reason, Creator: creator,
+		Created: time.Now(), Expires: expires}
+
+	// Use the proper spelling and character ID if they're in corp.
+	key := normalizeName(name)
+	for _, mt := range lastPull.members {
+		if normalizeName(mt.Name) == key {
+			e.CharacterID = mt.CharacterID
+			e.Name = mt.Name
+			break
+		}
+	}
+
+	err := saveExemption(key, e)
+	if err != nil {
+		return nil, err
+	}
+	exemptions[key] = e
+
+	return e, nil
+}
+
+func handleExemptions(w http.ResponseWriter, r *http.Request, ses Session) {
+	r.ParseForm()
+	username := ses.Get("username")
+
+	if r.Method == "POST" {
+		if !isAdmin(username) {
+			http.Error(w, "Not authorized.", http.StatusForbidden)
+			return
+		}
+
+		var changed bool
+		purgeLock.Lock()
+		switch r.PostFormValue("action") {
+		case "add":
+			expires, err := time.Parse(dateFormat, r.PostFormValue("expires"))
+			if err != nil {
+				ses.Set("exemptionError", "Expiry should be a date like 2006-01-02.")
+				break
+			}
+
+			e, err := addExemption(r.PostFormValue("name"), r.PostFormValue("reason"), username, expires)
+			if err != nil {
+				ses.Set("exemptionError", err.Error())
+				break
+			}
+			log.Printf("%s exempted %s until %s: %s", username, e.Name, e.Expires.Format(dateFormat), e.Reason)
+			recordAudit(username, "exempted", e.CharacterID, e.Name,
+				fmt.Sprintf("Until %s: %s", e.Expires.Format(dateFormat), e.Reason))
+			changed = true
+		case "remove":
+			key := r.PostFormValue("key")
+			if e, ok := exemptions[key]; ok {
+				delete(exemptions, key)
+				deleteExemption(key)
+				log.Printf("%s removed the exemption for %s.", username, e.Name)
+				recordAudit(username, "exemption removed", e.CharacterID, e.Name, e.Reason)
+				changed = true
+			}
+		}
+		purgeLock.Unlock()
+
+		if changed {
+			reevaluateRoster()
+		}
+
+		w.Header().Set("Location", "exemptions")
+		w.WriteHeader(http.StatusFound)
+		return
+	}
+
+	type exemptionRow struct {
+		Key string
+		*exemption
+	}
+	type ExemptionData struct {
+		Title   string
+		Error   string
+		CanEdit bool
+		Rows    []exemptionRow
+	}
+	ed := ExemptionData{Title: "Exemptions", Error: ses.Get("exemptionError"),
+		CanEdit: isAdmin(username)}
+	ses.Set("exemptionError", "")
+
+	purgeLock.RLock()
+	for k, e := range exemptions {
+		ed.Rows = append(ed.Rows, exemptionRow{k, e})
+	}
+	purgeLock.RUnlock()
+	sort.Slice(ed.Rows, func(i, j int) bool {
+		return ed.Rows[i].Expires.Before(ed.Rows[j].Expires)
+	})
+
+	renderPage(w, "exemptions", ed)
+}
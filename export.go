@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// exportTable is something that can be written out as CSV as well as JSON.
+type exportTable interface {
+	header() []string
+	records() [][]string
+}
+
+type queueRow struct {
+	CharacterID int64     `json:"characterID"`
+	Name        string    `json:"name"`
+	State       string    `json:"state"`
+	Reason      string    `json:"reason"`
+	LastLogin   time.Time `json:"lastLogin"`
+	Joined      time.Time `json:"joined"`
+	Roles       string    `json:"roles"`
+	ClaimedBy   string    `json:"claimedBy"`
+}
+
+type queueExport []queueRow
+
+func (q queueExport) header() []string {
+	return []string{"characterID", "name", "state", "reason", "lastLogin", "joined", "roles", "claimedBy"}
+}
+
+func (q queueExport) records() [][]string {
+	var recs [][]string
+	for _, r := range q {
+		recs = append(recs, []string{strconv.FormatInt(r.CharacterID, 10), r.Name, r.State,
+			r.Reason, formatTime(r.LastLogin), formatTime(r.Joined), r.Roles, r.ClaimedBy})
+	}
+	return recs
+}
+
+type completedRow struct {
+	Time        time.Time `json:"time"`
+	Operator    string    `json:"operator"`
+	Action      string    `json:"action"`
+	CharacterID int64     `json:"characterID"`
+	Name        string    `json:"name"`
+	Reason      string    `json:"reason"`
+}
+
+type completedExport []completedRow
+
+func (c completedExport) header() []string {
+	return []string{"time", "operator", "action", "characterID", "name", "reason"}
+}
+
+func (c completedExport) records() [][]string {
+	var recs [][]string
+	for _, r := range c {
+		recs = append(recs, []string{formatTime(r.Time), r.Operator, r.Action,
+			strconv.FormatInt(r.CharacterID, 10), r.Name, r.Reason})
+	}
+	return recs
+}
+
+// state describes where m is in the purge process.
+func (m *purgeMember) state() string {
+	switch {
+	case m.Purged:
+		return "kicked"
+	case time.Since(m.Stripped) <= 24*time.Hour:
+		return "stripped"
+	case !m.actionable():
+		return "on hold"
+	case time.Since(m.Claimed) <= time.Hour:
+		return "claimed"
+	case m.Roles:
+		return "to strip"
+	}
+	return "to kick"
+}
+
+// queueRows exports the purge queue, sorted by name.  Must be called with
+// purgeLock held.
+func queueRows() queueExport {
+	var rows queueExport
+	for _, m := range toBePurged {
+		r := queueRow{CharacterID: m.Id, Name: m.Name, State: m.state(), Reason: strings.TrimSpace(m.Reason),
+			LastLogin: m.LastLogin, Joined: m.Joined, Roles: m.RoleSets.String()}
+		if r.State == "claimed" {
+			r.ClaimedBy = m.ClaimedBy
+		}
+		rows = append(rows, r)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Name < rows[j].Name })
+	return rows
+}
+
+// completedRows exports confirmed strips and kicks between from and to,
+// oldest first.  Confirmations since undone are left out.
+func completedRows(from, to time.Time) completedExport {
+	events := readAudit(int(^uint(0)>>1), func(e *auditEvent) bool {
+		switch e.Action {
+		case "stripped", "kicked", "undid stripped", "undid kicked":
+			return !e.Time.Before(from) && e.Time.Before(to)
+		}
+		return false
+	})
+
+	rows := make(completedExport, 0, len(events))
+	for i := len(events) - 1; i >= 0; i-- {
+		e := events[i]
+		if !strings.HasPrefix(e.Action, "undid ") {
+			rows = append(rows, completedRow{e.Time, e.User, e.Action, e.CharacterID, e.Name, e.Detail})
+			continue
+		}
+
+		action := strings.TrimPrefix(e.Action, "undid ")
+		for j := len(rows) - 1; j >= 0; j-- {
+			if rows[j].CharacterID == e.CharacterID && rows[j].Action == action {
+				rows = append(rows[:j], rows[j+1:]...)
+				break
+			}
+		}
+	}
+	return rows
+}
+
+// exportRange parses a from/to date pair, defaulting to the last 30 days.
+// to is inclusive.
+func exportRange(fromStr, toStr string) (from, to time.Time, err error) {
+	to = time.Now()
+	from = to.Add(-30 * 24 * time.Hour)
+	if fromStr != "" {
+		from, err = time.Parse(dateFormat, fromStr)
+		if err != nil {
+			return from, to, fmt.Errorf("from date should look like 2006-01-02")
+		}
+	}
+	if toStr != "" {
+		to, err = time.Parse(dateFormat, toStr)
+		if err != nil {
+			return from, to, fmt.Errorf("to date should look like 2006-01-02")
+		}
+		to = to.Add(24 * time.Hour)
+	}
+	return from, to, nil
+}
+
+func writeExport(w io.Writer, format string, t exportTable) error {
+	if format == "json" {
+		return json.NewEncoder(w).Encode(t)
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write(t.header())
+	cw.WriteAll(t.records())
+	return cw.Error()
+}
+
+func serveExport(w http.ResponseWriter, r *http.Request, name string, t exportTable) {
+	format := r.FormValue("format")
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		format = "csv"
+		w.Header().Set("Content-Type", "text/csv")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-%s.%s",
+		name, time.Now().Format(dateFormat), format))
+
+	err := writeExport(w, format, t)
+	if err != nil {
+		log.Printf("Failed to write %s export: %s", name, err)
+	}
+}
+
+func handleExportQueue(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	purgeLock.RLock()
+	rows := queueRows()
+	purgeLock.RUnlock()
+
+	serveExport(w, r, "queue", rows)
+}
+
+func handleExportCompleted(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	from, to, err := exportRange(r.FormValue("from"), r.FormValue("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	serveExport(w, r, "completed", completedRows(from, to))
+}
+
+// exportCommand implements the export subcommand, reading the bolt DB
+// directly so it works while slopemaker is stopped.
+func exportCommand(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "csv", "csv or json")
+	fromStr := fs.String("from", "", "first day of completed purges, 2006-01-02")
+	toStr := fs.String("to", "", "last day of completed purges, 2006-01-02")
+	dbfile := fs.String("db", "", "bolt DB to read, boltDB from the config file by default")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s export [flags] queue|completed\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	what := fs.Arg(0)
+	if (what != "queue" && what != "completed") || (*format != "csv" && *format != "json") {
+		fs.Usage()
+		return 2
+	}
+
+	from, to, err := exportRange(*fromStr, *toStr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if *dbfile == "" {
+		c, err := readConfig(configFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Unable to read %s: %s\n", configFile, err)
+			return 1
+		}
+		*dbfile, _ = c.String("purger", "boltDB")
+	}
+
+	bdb, err = bolt.Open(*dbfile, 0644, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Unable to open %s, is slopemaker still running? %s\n", *dbfile, err)
+		return 1
+	}
+	defer bdb.Close()
+
+	var t exportTable
+	if what == "queue" {
+		loadState()
+		t = queueRows()
+	} else {
+		t = completedRows(from, to)
+	}
+
+	err = writeExport(os.Stdout, *format, t)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
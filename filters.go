@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/robfig/config"
+)
+
+// memberFilter picks out members by title, base or current location.
+// Titles must match a whole title, ignoring case, so "Alt" doesn't pick out
+// "Alt Holder".  Base and location entries match case insensitively anywhere
+// in the field, so "J123456" picks out everyone logged off anywhere in that
+// system.  Numeric base and location entries match the base or location ID
+// instead.
+type memberFilter struct {
+	titles    []string
+	bases     []string
+	locations []string
+}
+
+// parseMemberFilter reads the <prefix>Titles, <prefix>Bases and
+// <prefix>Locations options from [purger].
+func parseMemberFilter(c *config.Config, prefix string) memberFilter {
+	var f memberFilter
+
+	confStr, _ := c.String("purger", prefix+"Titles")
+	f.titles = splitList(strings.ToLower(confStr))
+	confStr, _ = c.String("purger", prefix+"Bases")
+	f.bases = splitList(strings.ToLower(confStr))
+	confStr, _ = c.String("purger", prefix+"Locations")
+	f.locations = splitList(strings.ToLower(confStr))
+
+	return f
+}
+
+// matchField returns the first entry matching the field's name or its ID.
+func matchField(entries []string, name string, id int64) string {
+	name = strings.ToLower(name)
+	for _, e := range entries {
+		if n, err := strconv.ParseInt(e, 10, 64); err == nil {
+			if n == id {
+				return e
+			}
+			continue
+		}
+		if strings.Contains(name, e) {
+			return e
+		}
+	}
+	return ""
+}
+
+// matchTitle returns the first entry equal to one of the comma separated
+// titles in title, ignoring case.
+func matchTitle(entries []string, title string) string {
+	titles := append(strings.Split(title, ","), title)
+	for _, e := range entries {
+		for _, t := range titles {
+			if strings.EqualFold(strings.TrimSpace(t), e) {
+				return e
+			}
+		}
+	}
+	return ""
+}
+
+// match describes why mt matches the filter, such as `title "reserve"`, or
+// returns "" if it doesn't.
+func (f memberFilter) match(mt MemberTrackingMember) string {
+	if e := matchTitle(f.titles, mt.Title); e != "" {
+		return `title "` + e + `"`
+	}
+	if e := matchField(f.bases, mt.Base, mt.BaseID); e != "" {
+		return `base "` + e + `"`
+	}
+	if e := matchField(f.locations, mt.Location, mt.LocationID); e != "" {
+		return `location "` + e + `"`
+	}
+	return ""
+}
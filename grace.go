@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// pendingMember is an unregistered new joiner still within the registration
+// grace period.
+type pendingMember struct {
+	Name     string
+	Id       int64
+	Joined   time.Time
+	DaysLeft int
+}
+
+// pendingMembers is rebuilt on every evaluation.  Protected by purgeLock.
+var pendingMembers []pendingMember
+
+// remindersSent maps character IDs to the smallest reminderDays threshold
+// already sent for them, so each reminder goes out once.  Saved with the rest
+// of the state, protected by purgeLock.
+var remindersSent = map[int64]int{}
+
+// graceDaysLeft returns how many days mt has left to register, or 0 if the
+// grace period is over or disabled.
+func graceDaysLeft(mt MemberTrackingMember) int {
+	left := registrationGrace - time.Since(mt.StartDateTime.Time)
+	if left <= 0 {
+		return 0
+	}
+	return int(math.Ceil(left.Hours() / 24))
+}
+
+// updatePending replaces the pending member list, sending any reminders now
+// due.  If registration is unknown nobody can be pending, so the reminders
+// already sent are kept for when it's known again.  Must be called with
+// purgeLock held.
+func updatePending(pending []pendingMember, unknown bool) {
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].DaysLeft < pending[j].DaysLeft
+	})
+	pendingMembers = pending
+
+	stillPending := map[int64]bool{}
+	for _, p := range pending {
+		stillPending[p.Id] = true
+
+		threshold := -1
+		for _, d := range reminderDays {
+			if p.DaysLeft <= d && (threshold < 0 || d < threshold) {
+				threshold = d
+			}
+		}
+		if threshold < 0 || reminderURL == "" {
+			continue
+		}
+		if sent, ok := remindersSent[p.Id]; ok && sent <= threshold {
+			continue
+		}
+
+		remindersSent[p.Id] = threshold
+		go sendReminder(reminderURL, p)
+	}
+
+	if unknown {
+		return
+	}
+
+	// Forget anyone who registered, left or ran out of time.
+	for id := range remindersSent {
+		if !stillPending[id] {
+			delete(remindersSent, id)
+		}
+	}
+}
+
+// sendReminder posts a pending member to the reminder webhook.  The text
+// field makes the payload usable directly with Slack style webhooks.
+func sendReminder(url string, p pendingMember) {
+	payload := map[string]interface{}{
+		"characterID":   p.Id,
+		"name":          p.Name,
+		"joined":        p.Joined.Format(ApiDateTimeFormat),
+		"daysRemaining": p.DaysLeft,
+		"text":          fmt.Sprintf("%s has %d day(s) left to register before being queued for removal.", p.Name, p.DaysLeft),
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Failed to build reminder for %s: %s", p.Name, err)
+		return
+	}
+
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Failed to send registration reminder for %s: %s", p.Name, err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		log.Printf("Registration reminder for %s got %s", p.Name, resp.Status)
+	}
+}
+
+func handlePending(w http.ResponseWriter, r *http.Request) {
+	purgeLock.RLock()
+	defer purgeLock.RUnlock()
+
+	type PendingData struct {
+		Title     string
+		GraceDays int
+		Members   []pendingMember
+	}
+	pd := PendingData{Title: "Pending Registration",
+		GraceDays: int(registrationGrace / (24 * time.Hour)), Members: pendingMembers}
+
+	renderPage(w, "pending", pd)
+}
@@ -0,0 +1,120 @@
+package main
+
+import "fmt"
+
+// memberGroups links corp members to their main, using the main/alt
+// grouping from the registration backend.  Characters with no known main are
+// their own main.
+type memberGroups struct {
+	// main holds each member's normalized main name by character ID.
+	main map[int64]string
+
+	// active is set for groups with any member who isn't idle.
+	active map[string]bool
+
+	// size counts the members in corp of each group.
+	size map[string]int
+
+	// ids maps normalized names of members to character IDs.
+	ids map[string]int64
+
+	// names maps normalized main names to the main's name as registered.
+	names map[string]string
+
+	// alts lists the alts in corp of each main.
+	alts map[string][]string
+}
+
+// groupMembers sorts members into groups, idle maps character IDs to whether
+// they're idle.
+func groupMembers(members []MemberTrackingMember, reg *Registrations, idle map[int64]bool) *memberGroups {
+	g := &memberGroups{main: map[int64]string{}, active: map[string]bool{},
+		size: map[string]int{}, ids: map[string]int64{}, names: map[string]string{},
+		alts: map[string][]string{}}
+
+	for _, mt := range members {
+		name := normalizeName(mt.Name)
+		g.ids[name] = mt.CharacterID
+
+		main := name
+		if m := reg.mainOf(mt.Name); m != "" {
+			main = normalizeName(m)
+			g.names[main] = m
+			g.alts[main] = append(g.alts[main], mt.Name)
+		}
+
+		g.main[mt.CharacterID] = main
+		g.size[main]++
+		if !idle[mt.CharacterID] {
+			g.active[main] = true
+		}
+	}
+
+	return g
+}
+
+// mainName returns the name of mt's main, or "" if it is a main.
+func (g *memberGroups) mainName(mt MemberTrackingMember) string {
+	main := g.main[mt.CharacterID]
+	if main == normalizeName(mt.Name) {
+		return ""
+	}
+	return g.names[main]
+}
+
+// activeAlts reports whether mt belongs to a group with an active member,
+// which keeps the whole group from counting as idle.
+func (g *memberGroups) activeAlts(mt MemberTrackingMember) bool {
+	main := g.main[mt.CharacterID]
+	return g.size[main] > 1 && g.active[main]
+}
+
+// mainID returns the character ID of mt's main if the main is in corp.
+func (g *memberGroups) mainID(mt MemberTrackingMember) (int64, bool) {
+	id, ok := g.ids[g.main[mt.CharacterID]]
+	return id, ok
+}
+
+// queueAlts adds the alts of every queued main to newPurge, along with the
+// alts of mains who aren't in corp at all.  Must be called with purgeLock
+// held.
+func queueAlts(newPurge map[int64]*purgeMember, members []MemberTrackingMember, g *memberGroups) {
+	for _, mt := range members {
+		main := g.mainName(mt)
+		if main == "" || exempt(mt) {
+			continue
+		}
+
+		reason := fmt.Sprintf("Alt of %s, who is queued for removal. ", main)
+		mainID, inCorp := g.mainID(mt)
+		if !inCorp {
+			reason = fmt.Sprintf("Alt of %s, who is not in corp. ", main)
+		} else if newPurge[mainID] == nil {
+			continue
+		}
+
+		m, ok := newPurge[mt.CharacterID]
+		if !ok {
+			m = &purgeMember{Name: mt.Name, Id: mt.CharacterID,
+				Joined: mt.StartDateTime.Time, LastLogin: mt.LogonDateTime.Time,
+				ShipType: mt.ShipType, Title: mt.Title, Base: mt.Base,
+				Location: mt.Location}
+			m.RoleSets = memberRoles(mt)
+			m.Roles = m.RoleSets.any()
+			if oldm, ok := toBePurged[mt.CharacterID]; ok {
+				m.carryStrip(oldm)
+			}
+			newPurge[mt.CharacterID] = m
+		}
+		m.MainQueued = true
+		m.Reason = reason + m.Reason
+	}
+
+	for _, m := range newPurge {
+		mt := MemberTrackingMember{CharacterID: m.Id, Name: m.Name}
+		m.Main = g.mainName(mt)
+		if m.Main == "" {
+			m.Alts = g.alts[g.main[m.Id]]
+		}
+	}
+}
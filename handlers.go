@@ -20,9 +20,20 @@ func daysSince(t time.Time) string {
 	return fmt.Sprintf("%d", days)
 }
 
+// percent is n as a whole percentage of total.
+func percent(n, total int) int {
+	if total == 0 {
+		return 0
+	}
+	return n * 100 / total
+}
+
 var tFuncMap = template.FuncMap{
 	"datetime":  formatTime,
 	"dayssince": daysSince,
+	"join":      strings.Join,
+	"roles":     roleSets.named,
+	"percent":   percent,
 }
 
 //apparently my sessions are bad and i should feel bad.
@@ -53,7 +64,7 @@ func handleStrip(w http.ResponseWriter, r *http.Request, ses Session) {
 
 	purgeLock.Lock()
 	defer purgeLock.Unlock()
-	go saveState()
+	queueSave()
 
 	victimsStr := ses.Get("strip_victims")
 	victims := strToVictims(victimsStr)
@@ -81,7 +92,11 @@ func handleStrip(w http.ResponseWriter, r *http.Request, ses Session) {
 			// mark as purged if confirmation, unclaim it otherwise.
 			if confirmed {
 				log.Printf("Confirming %s as stripped by %s.", toBePurged[id].Name, ses.Get("username"))
-				toBePurged[id].Stripped = time.Now()
+				now := time.Now()
+				noteConfirmation("stripped", ses.Get("username"), toBePurged[id], now)
+				toBePurged[id].Stripped = now
+				toBePurged[id].RolesAtStrip = toBePurged[id].RoleSets
+				recordAudit(ses.Get("username"), "stripped", id, toBePurged[id].Name, toBePurged[id].Reason)
 			}
 			toBePurged[id].Claimed = time.Time{}
 		}
@@ -98,7 +113,8 @@ func handleStrip(w http.ResponseWriter, r *http.Request, ses Session) {
 	if r.PostFormValue("claim") == "victims" {
 		var victims []int64
 		count := 0
-		for id, m := range toBePurged {
+		for _, id := range queueOrder() {
+			m := toBePurged[id]
 			if !m.Roles {
 				// Skip anything without roles
 				continue
@@ -114,14 +130,14 @@ func handleStrip(w http.ResponseWriter, r *http.Request, ses Session) {
 				continue
 			}
 
-			// IsRegistered is nil for registered characters
-			if m.IsRegistered != nil {
-				if m.IsRegistered() == true {
-					continue
-				}
+			// Skip anything whose registration is in doubt, checking
+			// unregistered characters haven't since signed up.
+			if !m.actionable() || m.recheckRegistration() {
+				continue
 			}
 
 			toBePurged[id].Claimed = time.Now()
+			toBePurged[id].ClaimedBy = ses.Get("username")
 			victims = append(victims, id)
 			count++
 			if count >= 10 {
@@ -134,17 +150,15 @@ func handleStrip(w http.ResponseWriter, r *http.Request, ses Session) {
 		return
 	}
 
-	stripTemplate, err := template.New("base.html").Funcs(tFuncMap).ParseFiles("templates/base.html", "templates/strip.html")
-	if err != nil {
-		log.Printf("Template error: %s", err)
-		return
-	}
-
 	type StripData struct {
 		Title   string
+		Error   string
 		Members []purgeMember
+		Recent  []confirmation
 	}
-	sd := StripData{Title: "Strip Roles"}
+	sd := StripData{Title: "Strip Roles", Error: ses.Get("undoError"),
+		Recent: undoableConfirmations("stripped", ses.Get("username"))}
+	ses.Set("undoError", "")
 
 	for _, id := range victims {
 		m, ok := toBePurged[id]
@@ -163,13 +177,11 @@ func handleStrip(w http.ResponseWriter, r *http.Request, ses Session) {
 		}
 
 		toBePurged[id].Claimed = time.Now()
+		toBePurged[id].ClaimedBy = ses.Get("username")
 		sd.Members = append(sd.Members, *m)
 	}
 
-	err = stripTemplate.Execute(w, sd)
-	if err != nil {
-		log.Printf("Template Error: %s", err)
-	}
+	renderPage(w, "strip", sd)
 }
 
 func handleBoot(w http.ResponseWriter, r *http.Request, ses Session) {
@@ -177,7 +189,7 @@ func handleBoot(w http.ResponseWriter, r *http.Request, ses Session) {
 
 	purgeLock.Lock()
 	defer purgeLock.Unlock()
-	go saveState()
+	queueSave()
 
 	victimsStr := ses.Get("boot_victims")
 	victims := strToVictims(victimsStr)
@@ -205,7 +217,9 @@ func handleBoot(w http.ResponseWriter, r *http.Request, ses Session) {
 			// mark as purged if confirmation, unclaim it
 			if confirmed {
 				log.Printf("Confirming %s as purged by %s.", toBePurged[id].Name, ses.Get("username"))
+				noteConfirmation("kicked", ses.Get("username"), toBePurged[id], time.Now())
 				toBePurged[id].Purged = true
+				recordAudit(ses.Get("username"), "kicked", id, toBePurged[id].Name, toBePurged[id].Reason)
 			}
 			toBePurged[id].Claimed = time.Time{}
 		}
@@ -222,7 +236,8 @@ func handleBoot(w http.ResponseWriter, r *http.Request, ses Session) {
 	if r.PostFormValue("claim") == "victims" {
 		var victims []int64
 		count := 0
-		for id, m := range toBePurged {
+		for _, id := range queueOrder() {
+			m := toBePurged[id]
 			if m.Roles || m.Purged {
 				// Skip anything with roles or that we think we've purged
 				continue
@@ -238,14 +253,14 @@ func handleBoot(w http.ResponseWriter, r *http.Request, ses Session) {
 				continue
 			}
 
-			// IsRegistered is nil for registered characters
-			if m.IsRegistered != nil {
-				if m.IsRegistered() == true {
-					continue
-				}
+			// Skip anything whose registration is in doubt, checking
+			// unregistered characters haven't since signed up.
+			if !m.actionable() || m.recheckRegistration() {
+				continue
 			}
 
 			toBePurged[id].Claimed = time.Now()
+			toBePurged[id].ClaimedBy = ses.Get("username")
 			victims = append(victims, id)
 			count++
 			if count >= 10 {
@@ -258,17 +273,15 @@ func handleBoot(w http.ResponseWriter, r *http.Request, ses Session) {
 		return
 	}
 
-	bootTemplate, err := template.New("base.html").Funcs(tFuncMap).ParseFiles("templates/base.html", "templates/boot.html")
-	if err != nil {
-		log.Printf("Template error: %s", err)
-		return
-	}
-
 	type BootData struct {
 		Title   string
+		Error   string
 		Members []purgeMember
+		Recent  []confirmation
 	}
-	bd := BootData{Title: "Slopes for the Slope Throne"}
+	bd := BootData{Title: "Slopes for the Slope Throne", Error: ses.Get("undoError"),
+		Recent: undoableConfirmations("kicked", ses.Get("username"))}
+	ses.Set("undoError", "")
 
 	for _, id := range victims {
 		m, ok := toBePurged[id]
@@ -287,25 +300,15 @@ func handleBoot(w http.ResponseWriter, r *http.Request, ses Session) {
 		}
 
 		toBePurged[id].Claimed = time.Now()
+		toBePurged[id].ClaimedBy = ses.Get("username")
 		bd.Members = append(bd.Members, *m)
 	}
 
-	err = bootTemplate.Execute(w, bd)
-	if err != nil {
-		log.Printf("Template Error: %s", err)
-	}
+	renderPage(w, "boot", bd)
 }
 
 func handleRoot(w http.ResponseWriter, r *http.Request, ses Session) {
-	rootTemplate, err := template.New("base.html").Funcs(tFuncMap).ParseFiles("templates/base.html", "templates/root.html")
-	if err != nil {
-		log.Printf("Template error: %s", err)
-		return
-	}
-	err = rootTemplate.Execute(w, map[string]string{"Title": "Slope Maker"})
-	if err != nil {
-		log.Printf("Template Error: %s", err)
-	}
+	renderPage(w, "root", map[string]string{"Title": "Slope Maker"})
 }
 
 func handleStats(w http.ResponseWriter, r *http.Request) {
@@ -322,6 +325,7 @@ func printStats(w io.Writer) {
 	var needsPurged int
 	var inStasis int
 	var claimed int
+	var unknown int
 
 	for _, m := range toBePurged {
 		totalMembers++
@@ -337,13 +341,49 @@ func printStats(w io.Writer) {
 		if !m.Roles && time.Since(m.Stripped) > 24*time.Hour {
 			needsPurged++
 		}
+		if m.RegistrationUnknown {
+			unknown++
+		}
 	}
 	fmt.Fprintf(w, "Total: %d  Claimed: %d  ToBePurged:  %d\n", totalMembers, claimed, needsPurged)
-	fmt.Fprintf(w, "ToBeStripped: %d  InStasis: %d\n\n--------------\n", needsStripped, inStasis)
+	fmt.Fprintf(w, "ToBeStripped: %d  InStasis: %d  RegistrationUnknown: %d\n\n--------------\n", needsStripped, inStasis, unknown)
 	for _, m := range toBePurged {
 		fmt.Fprintf(w, "%s	%s\n", m.Name, m.Reason)
 	}
+	if len(registrationMismatches) > 0 {
+		fmt.Fprintf(w, "\n--------------\nRegistration mismatches: %d\n", len(registrationMismatches))
+		for _, m := range registrationMismatches {
+			fmt.Fprintf(w, "%s\n", m)
+		}
+	}
 
 	log.Printf("Total: %d  Claimed: %d  ToBePurged:  %d", totalMembers, claimed, needsPurged)
 	log.Printf("ToBeStripped: %d  InStasis: %d", needsStripped, inStasis)
 }
+
+func handleReload(w http.ResponseWriter, r *http.Request, ses Session) {
+	w.Header().Set("Content-Type", "text/plain")
+
+	username := ses.Get("username")
+	if !isAdmin(username) {
+		http.Error(w, "Not authorized.", http.StatusForbidden)
+		return
+	}
+
+	added, removed, err := reloadConfig()
+	if err != nil {
+		log.Printf("Config reload by %s rejected: %s", username, err)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		fmt.Fprintf(w, "Config rejected, keeping previous configuration.\n\n%s\n", err)
+		return
+	}
+	log.Printf("Config reloaded by %s.", username)
+
+	fmt.Fprintf(w, "Reloaded configuration. Added: %d  Removed: %d\n\n--------------\n", len(added), len(removed))
+	for _, m := range added {
+		fmt.Fprintf(w, "+ %s	%s\n", m.Name, m.Reason)
+	}
+	for _, m := range removed {
+		fmt.Fprintf(w, "- %s	%s\n", m.Name, m.Reason)
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// historyRetention is how long roster snapshots are kept, zero disables
+// them.  Protected by purgeLock.
+var historyRetention time.Duration
+
+const defaultHistoryDays = 180
+
+// rosterChange is one difference between consecutive roster pulls.
+type rosterChange struct {
+	Time        time.Time
+	Kind        string
+	CharacterID int64
+	Name        string
+	Detail      string
+}
+
+func historyKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return key
+}
+
+func historyTime(key []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key)))
+}
+
+func encodeSnapshot(members []MemberTrackingMember) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	err := gob.NewEncoder(zw).Encode(members)
+	if err != nil {
+		return nil, err
+	}
+	err = zw.Close()
+	return buf.Bytes(), err
+}
+
+func decodeSnapshot(data []byte) ([]MemberTrackingMember, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var members []MemberTrackingMember
+	err = gob.NewDecoder(zr).Decode(&members)
+	return members, err
+}
+
+// diffRosters lists joins, leaves, role changes and title changes between two
+// pulls.  Role changes are skipped if cur's roles are stale.
+func diffRosters(pulled time.Time, old, cur []MemberTrackingMember, staleRoles bool) []rosterChange {
+	var changes []rosterChange
+	change := func(kind string, mt MemberTrackingMember, detail string) {
+		changes = append(changes, rosterChange{pulled, kind, mt.CharacterID, mt.Name, detail})
+	}
+
+	oldByID := make(map[int64]MemberTrackingMember, len(old))
+	for _, mt := range old {
+		oldByID[mt.CharacterID] = mt
+	}
+
+	for _, mt := range cur {
+		prev, ok := oldByID[mt.CharacterID]
+		if !ok {
+			change("joined", mt, "")
+			continue
+		}
+		delete(oldByID, mt.CharacterID)
+
+		before, after := memberRoles(prev), memberRoles(mt)
+		if before != after && !staleRoles {
+			var detail []string
+			if gained := decodeRoles(after.all() &^ before.all()); gained != nil {
+				detail = append(detail, "gained "+strings.Join(gained, ", "))
+			}
+			if lost := decodeRoles(before.all() &^ after.all()); lost != nil {
+				detail = append(detail, "lost "+strings.Join(lost, ", "))
+			}
+			if detail == nil {
+				detail = append(detail, "now "+after.String())
+			}
+			change("roles", mt, strings.Join(detail, "; "))
+		}
+		if prev.Title != mt.Title {
+			change("title", mt, fmt.Sprintf("%q to %q", prev.Title, mt.Title))
+		}
+	}
+
+	for _, mt := range old {
+		if _, ok := oldByID[mt.CharacterID]; ok {
+			change("left", mt, "")
+		}
+	}
+
+	return changes
+}
+
+// saveSnapshot stores a roster pull along with its changes from the previous
+// one, and drops anything older than the retention period.  staleRoles is set
+// if the pull's roles were carried over rather than fetched.
+func saveSnapshot(pulled time.Time, members []MemberTrackingMember, staleRoles bool) {
+	purgeLock.RLock()
+	retention := historyRetention
+	purgeLock.RUnlock()
+	if retention == 0 {
+		return
+	}
+
+	data, err := encodeSnapshot(members)
+	if err != nil {
+		log.Printf("Failed to encode roster snapshot: %s", err)
+		return
+	}
+
+	err = bdb.Update(func(tx *bolt.Tx) error {
+		err := indexHistory(tx)
+		if err != nil {
+			return err
+		}
+		snaps, err := tx.CreateBucketIfNotExists([]byte("snapshots"))
+		if err != nil {
+			return err
+		}
+		changes, err := tx.CreateBucketIfNotExists([]byte("changes"))
+		if err != nil {
+			return err
+		}
+
+		var old []MemberTrackingMember
+		if _, prev := snaps.Cursor().Last(); prev != nil {
+			old, err = decodeSnapshot(prev)
+			if err != nil {
+				return err
+			}
+
+			diff := diffRosters(pulled, old, members, staleRoles)
+			err = putGob(changes, historyKey(pulled), diff)
+			if err != nil {
+				return err
+			}
+			err = indexChanges(tx, diff)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = snaps.Put(historyKey(pulled), data)
+		if err != nil {
+			return err
+		}
+		err = indexRoster(tx, pulled, old, members, staleRoles)
+		if err != nil {
+			return err
+		}
+
+		cutoff := time.Now().Add(-retention)
+		for _, b := range []*bolt.Bucket{snaps, changes} {
+			c := b.Cursor()
+			for k, _ := c.First(); k != nil && bytes.Compare(k, historyKey(cutoff)) < 0; k, _ = c.First() {
+				err = c.Delete()
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return pruneMemberHistory(tx, cutoff, members)
+	})
+	if err != nil {
+		log.Printf("Failed to save roster snapshot: %s", err)
+	}
+}
+
+// rosterAt returns the roster as of t, from the last pull at or before it.
+func rosterAt(t time.Time) (pulled time.Time, members []MemberTrackingMember, err error) {
+	err = bdb.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("snapshots"))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		k, v := c.Seek(historyKey(t.Add(1)))
+		if k == nil {
+			k, v = c.Last()
+		} else {
+			k, v = c.Prev()
+		}
+		if k == nil {
+			return nil
+		}
+
+		pulled = historyTime(k)
+		members, err = decodeSnapshot(v)
+		return err
+	})
+	return pulled, members, err
+}
+
+// eachDailySnapshot calls fn with the last stored roster of each day since
+// t, oldest first.  Only those rosters are decoded.
+func eachDailySnapshot(since time.Time, fn func(pulled time.Time, members []MemberTrackingMember)) error {
+	return bdb.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("snapshots"))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		for k, v := c.Seek(historyKey(since)); k != nil; {
+			pulled := historyTime(k)
+			nk, nv := c.Next()
+			if nk != nil && historyTime(nk).Format(dateFormat) == pulled.Format(dateFormat) {
+				k, v = nk, nv
+				continue
+			}
+
+			members, err := decodeSnapshot(v)
+			if err != nil {
+				return err
+			}
+			fn(pulled, members)
+			k, v = nk, nv
+		}
+		return nil
+	})
+}
+
+// rosterChanges returns the changes recorded since t, newest first.
+func rosterChanges(since time.Time) ([]rosterChange, error) {
+	var all []rosterChange
+	err := bdb.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("changes"))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		min := historyKey(since)
+		for k, v := c.Last(); k != nil && bytes.Compare(k, min) >= 0; k, v = c.Prev() {
+			var changes []rosterChange
+			err := gob.NewDecoder(bytes.NewReader(v)).Decode(&changes)
+			if err != nil {
+				return err
+			}
+			all = append(all, changes...)
+		}
+		return nil
+	})
+	return all, err
+}
+
+func handleHistory(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	type HistoryData struct {
+		Title   string
+		Days    int
+		Date    string
+		Pulled  time.Time
+		Roster  []MemberTrackingMember
+		Changes []rosterChange
+	}
+	hd := HistoryData{Title: "Roster History", Days: 7, Date: r.FormValue("date")}
+
+	if hd.Date != "" {
+		date, err := time.Parse(dateFormat, hd.Date)
+		if err != nil {
+			http.Error(w, "Date should look like 2006-01-02.", http.StatusBadRequest)
+			return
+		}
+
+		// The roster as it stood at the end of that day.
+		hd.Pulled, hd.Roster, err = rosterAt(date.Add(24*time.Hour - 1))
+		if err != nil {
+			log.Printf("Failed to read roster history: %s", err)
+		}
+	} else {
+		if d, err := strconv.Atoi(r.FormValue("days")); err == nil && d > 0 {
+			hd.Days = d
+		}
+
+		var err error
+		hd.Changes, err = rosterChanges(time.Now().Add(-time.Duration(hd.Days) * 24 * time.Hour))
+		if err != nil {
+			log.Printf("Failed to read roster history: %s", err)
+		}
+	}
+
+	renderPage(w, "history", hd)
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// retryInterval is how soon a failed fetch of the registered member list is
+// retried.
+const retryInterval = time.Minute
+
+// HTTPTrackerOptions controls how the registered member list is fetched and
+// parsed.
+type HTTPTrackerOptions struct {
+	// Headers are added to every request, such as Authorization.
+	Headers http.Header
+
+	// Format is "text" for one name per line, or "json".
+	Format string
+
+	// JSONPath is the dot separated path to the list of characters within
+	// the JSON document, empty if the document is the list itself.
+	JSONPath string
+
+	// NameField, IDField and MainField are dot separated paths to the name,
+	// character ID and main's name within each entry.  Entries may also be
+	// plain strings.
+	NameField string
+	IDField   string
+	MainField string
+
+	// Refresh is how often the list is fetched, an hour if zero.
+	Refresh time.Duration
+}
+
+type HTTPCorpMemberTracker struct {
+	url  string
+	opts HTTPTrackerOptions
+
+	lastUpdate   time.Time
+	lastAttempt  time.Time
+	lastErr      error
+	etag         string
+	lastModified string
+	cached       *Registrations
+
+	client http.Client
+
+	sync.RWMutex
+}
+
+func NewHTTPCorpMemberTracker(url string, opts HTTPTrackerOptions) *HTTPCorpMemberTracker {
+	var n HTTPCorpMemberTracker
+	n.url = url
+	n.opts = opts
+	if n.opts.Refresh == 0 {
+		n.opts.Refresh = time.Hour
+	}
+	if n.opts.NameField == "" {
+		n.opts.NameField = "name"
+	}
+	n.cached = newRegistrations(false)
+	n.client.Timeout = 60 * time.Second
+
+	n.update()
+
+	return &n
+}
+
+// update fetches the registered member list if it's due.  The previous list
+// is kept if the server says it's unchanged or the fetch fails.
+func (hcmt *HTTPCorpMemberTracker) update() {
+	hcmt.Lock()
+	defer hcmt.Unlock()
+
+	if time.Since(hcmt.lastUpdate) < hcmt.opts.Refresh || time.Since(hcmt.lastAttempt) < retryInterval {
+		return
+	}
+	hcmt.lastAttempt = time.Now()
+
+	err := hcmt.fetch()
+	if err != nil {
+		log.Printf("Error getting registered member list: %s", err)
+		hcmt.lastErr = err
+		return
+	}
+
+	hcmt.lastErr = nil
+	hcmt.lastUpdate = time.Now()
+}
+
+func (hcmt *HTTPCorpMemberTracker) fetch() error {
+	req, err := http.NewRequest("GET", hcmt.url, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range hcmt.opts.Headers {
+		req.Header[k] = v
+	}
+	if hcmt.etag != "" {
+		req.Header.Set("If-None-Match", hcmt.etag)
+	}
+	if hcmt.lastModified != "" {
+		req.Header.Set("If-Modified-Since", hcmt.lastModified)
+	}
+
+	resp, err := hcmt.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s, keeping previous list", hcmt.url, resp.Status)
+	}
+
+	var newNames *Registrations
+	if hcmt.opts.Format == "json" {
+		newNames, err = parseJSONMembers(resp.Body, hcmt.opts)
+	} else {
+		newNames, err = parseTextMembers(resp.Body)
+	}
+	if err != nil {
+		return fmt.Errorf("reading registered member list: %s", err)
+	}
+
+	hcmt.cached = newNames
+	hcmt.etag = resp.Header.Get("ETag")
+	hcmt.lastModified = resp.Header.Get("Last-Modified")
+	return nil
+}
+
+// parseTextMembers reads one character name per line, optionally preceded by
+// the character ID and a comma, and optionally followed by a comma and the
+// name of the character's main.
+func parseTextMembers(r io.Reader) (*Registrations, error) {
+	newNames := newRegistrations(true)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var id int64
+		if comma := strings.Index(line, ","); comma > 0 {
+			if i, err := strconv.ParseInt(strings.TrimSpace(line[:comma]), 10, 64); err == nil {
+				id = i
+				line = line[comma+1:]
+			}
+		}
+		var main string
+		if comma := strings.Index(line, ","); comma >= 0 {
+			line, main = line[:comma], line[comma+1:]
+		}
+		newNames.add(id, line)
+		newNames.addMain(line, main)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// A plain list of names carries no IDs to match on.
+	if len(newNames.IDs) == 0 {
+		newNames.IDs = nil
+		newNames.idByName = nil
+	}
+	return newNames, nil
+}
+
+// jsonLookup follows a dot separated path through nested JSON objects.
+func jsonLookup(v interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return v, true
+	}
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		v, ok = obj[key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// parseJSONMembers reads a JSON list of characters, each either a name or an
+// object holding the name and optionally the character ID.
+func parseJSONMembers(r io.Reader, opts HTTPTrackerOptions) (*Registrations, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+
+	var doc interface{}
+	err := dec.Decode(&doc)
+	if err != nil {
+		return nil, err
+	}
+
+	v, ok := jsonLookup(doc, opts.JSONPath)
+	if !ok {
+		return nil, fmt.Errorf("no '%s' in document", opts.JSONPath)
+	}
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("'%s' is not a list", opts.JSONPath)
+	}
+
+	newNames := newRegistrations(opts.IDField != "")
+	for _, entry := range list {
+		if name, ok := entry.(string); ok {
+			newNames.add(0, name)
+			continue
+		}
+
+		nv, _ := jsonLookup(entry, opts.NameField)
+		name, ok := nv.(string)
+		if !ok || name == "" {
+			continue
+		}
+
+		var id int64
+		if opts.IDField != "" {
+			iv, _ := jsonLookup(entry, opts.IDField)
+			switch iv := iv.(type) {
+			case json.Number:
+				id, _ = iv.Int64()
+			case string:
+				id, _ = strconv.ParseInt(iv, 10, 64)
+			}
+		}
+		newNames.add(id, name)
+
+		if opts.MainField != "" {
+			mv, _ := jsonLookup(entry, opts.MainField)
+			if main, ok := mv.(string); ok {
+				newNames.addMain(name, main)
+			}
+		}
+	}
+
+	return newNames, nil
+}
+
+func (hcmt *HTTPCorpMemberTracker) GetRegistrations() (*Registrations, error) {
+	hcmt.update()
+
+	hcmt.RLock()
+	defer hcmt.RUnlock()
+
+	if hcmt.lastUpdate.IsZero() {
+		return nil, fmt.Errorf("registered member list not fetched yet: %v", hcmt.lastErr)
+	}
+
+	reg := newRegistrations(hcmt.cached.IDs != nil)
+	for k, v := range hcmt.cached.Names {
+		reg.Names[k] = v
+	}
+	for k, v := range hcmt.cached.IDs {
+		reg.IDs[k] = v
+	}
+	if reg.IDs != nil {
+		for k, v := range hcmt.cached.idByName {
+			reg.idByName[k] = v
+		}
+	}
+	for k, v := range hcmt.cached.mains {
+		reg.addMain(k, v)
+	}
+
+	return reg, nil
+}
+
+func (hcmt *HTTPCorpMemberTracker) GetMemberMap() (map[string]bool, error) {
+	reg, err := hcmt.GetRegistrations()
+	if err != nil {
+		return nil, err
+	}
+	return reg.memberMap(), nil
+}
+
+func (hcmt *HTTPCorpMemberTracker) IsRegistered(name string) (bool, error) {
+	hcmt.update()
+
+	hcmt.RLock()
+	defer hcmt.RUnlock()
+
+	if hcmt.lastUpdate.IsZero() {
+		return false, fmt.Errorf("registered member list not fetched yet: %v", hcmt.lastErr)
+	}
+
+	_, ok := hcmt.cached.Names[normalizeName(name)]
+	return ok, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/robfig/config"
+)
+
+// idleTiers override maxIdle for particular kinds of member.  Zero limits
+// are unset.
+type idleTiers struct {
+	// roles applies to anyone holding roles, who are a risk while idle.
+	roles time.Duration
+
+	// tenured applies to members who joined more than tenure ago.
+	tenure  time.Duration
+	tenured time.Duration
+
+	// neverLoggedOn applies to characters that haven't logged on since
+	// joining, counted from their join date.  Otherwise they're treated like
+	// anyone else.
+	neverLoggedOn time.Duration
+}
+
+// parseIdleTiers reads the optional tiered idle options from [purger].
+func parseIdleTiers(cerr *ConfigError, c *config.Config) idleTiers {
+	days := func(option string) time.Duration {
+		confStr, _ := c.String("purger", option)
+		if confStr == "" {
+			return 0
+		}
+		d, err := strconv.Atoi(confStr)
+		if err != nil || d <= 0 {
+			cerr.add("[purger] %s '%s' must be a positive number of days", option, confStr)
+			return 0
+		}
+		return time.Duration(d) * time.Hour * 24
+	}
+
+	var t idleTiers
+	t.roles = days("maxIdleDaysRoles")
+	t.tenure = days("tenureDays")
+	t.tenured = days("maxIdleDaysTenured")
+	t.neverLoggedOn = days("maxIdleDaysNeverLoggedOn")
+
+	if (t.tenure == 0) != (t.tenured == 0) {
+		cerr.add("[purger] tenureDays and maxIdleDaysTenured must be set together")
+	}
+
+	return t
+}
+
+func tierDays(d time.Duration) int {
+	return int(d / (24 * time.Hour))
+}
+
+// idleReason explains why mt counts as idle under the tier that applies to
+// it, or returns "" if it isn't idle.
+func idleReason(mt MemberTrackingMember) string {
+	logon := mt.LogonDateTime.Time
+	if idleLimits.neverLoggedOn != 0 && !logon.After(mt.StartDateTime.Time) {
+		if time.Since(mt.StartDateTime.Time) > idleLimits.neverLoggedOn {
+			return fmt.Sprintf("Never logged on, joined %s days ago. ", daysSince(mt.StartDateTime.Time))
+		}
+		return ""
+	}
+
+	idle := time.Since(logon)
+	switch {
+	case idleLimits.roles != 0 && memberRoles(mt).any():
+		if idle > idleLimits.roles {
+			return fmt.Sprintf("Idle %s days, over the %d day limit for role holders. ",
+				daysSince(logon), tierDays(idleLimits.roles))
+		}
+	case idleLimits.tenure != 0 && time.Since(mt.StartDateTime.Time) > idleLimits.tenure:
+		if idle > idleLimits.tenured {
+			return fmt.Sprintf("Idle %s days, over the %d day limit for members of %d days. ",
+				daysSince(logon), tierDays(idleLimits.tenured), tierDays(idleLimits.tenure))
+		}
+	default:
+		if idle > maxIdle {
+			return fmt.Sprintf("Idle %s days. ", daysSince(logon))
+		}
+	}
+	return ""
+}
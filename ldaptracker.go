@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAPTrackerOptions describes where registered characters live in the
+// directory.
+type LDAPTrackerOptions struct {
+	// BindDN and BindPassword are the service credentials to bind with.
+	BindDN       string
+	BindPassword string
+
+	// StartTLS upgrades an ldap:// connection before binding.
+	StartTLS bool
+
+	// BaseDN and Filter select registered character entries.
+	BaseDN string
+	Filter string
+
+	// NameAttr holds the character name, IDAttr the character ID if the
+	// directory has one.
+	NameAttr string
+	IDAttr   string
+
+	// Refresh is how often the full list is fetched, an hour if zero.
+	Refresh time.Duration
+
+	// Dial opens the connection, ldap.DialURL if nil.
+	Dial func(url string) (*ldap.Conn, error)
+}
+
+// LDAPCorpMemberTracker finds registered characters with an LDAP search,
+// typically the members of a group.
+type LDAPCorpMemberTracker struct {
+	url  string
+	opts LDAPTrackerOptions
+
+	lastUpdate  time.Time
+	lastAttempt time.Time
+	lastErr     error
+	cached      *Registrations
+
+	sync.RWMutex
+}
+
+func NewLDAPCorpMemberTracker(url string, opts LDAPTrackerOptions) *LDAPCorpMemberTracker {
+	l := newLDAPTracker(url, opts)
+	l.update()
+
+	return l
+}
+
+// newLDAPTracker fills in the option defaults without fetching anything.
+func newLDAPTracker(url string, opts LDAPTrackerOptions) *LDAPCorpMemberTracker {
+	var l LDAPCorpMemberTracker
+	l.url = url
+	l.opts = opts
+	if l.opts.Refresh == 0 {
+		l.opts.Refresh = time.Hour
+	}
+	if l.opts.NameAttr == "" {
+		l.opts.NameAttr = "cn"
+	}
+	if l.opts.Filter == "" {
+		l.opts.Filter = "(objectClass=*)"
+	}
+	if l.opts.Dial == nil {
+		l.opts.Dial = func(url string) (*ldap.Conn, error) { return ldap.DialURL(url) }
+	}
+	l.cached = newRegistrations(false)
+
+	return &l
+}
+
+// connect dials and binds with the service credentials.
+func (l *LDAPCorpMemberTracker) connect() (*ldap.Conn, error) {
+	conn, err := l.opts.Dial(l.url)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(30 * time.Second)
+
+	if l.opts.StartTLS {
+		u, err := url.Parse(l.url)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		err = conn.StartTLS(&tls.Config{ServerName: u.Hostname()})
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("starttls: %s", err)
+		}
+	}
+
+	if l.opts.BindDN != "" {
+		err = conn.Bind(l.opts.BindDN, l.opts.BindPassword)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("bind as %s: %s", l.opts.BindDN, err)
+		}
+	}
+
+	return conn, nil
+}
+
+// search runs filter under the base DN, returning the matching characters.
+func (l *LDAPCorpMemberTracker) search(filter string) (*Registrations, error) {
+	conn, err := l.connect()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	attrs := []string{l.opts.NameAttr}
+	if l.opts.IDAttr != "" {
+		attrs = append(attrs, l.opts.IDAttr)
+	}
+
+	req := ldap.NewSearchRequest(l.opts.BaseDN, ldap.ScopeWholeSubtree,
+		ldap.NeverDerefAliases, 0, 0, false, filter, attrs, nil)
+	res, err := conn.SearchWithPaging(req, 500)
+	if err != nil {
+		return nil, fmt.Errorf("search %s: %s", filter, err)
+	}
+
+	reg := newRegistrations(l.opts.IDAttr != "")
+	for _, e := range res.Entries {
+		name := e.GetAttributeValue(l.opts.NameAttr)
+		if name == "" {
+			continue
+		}
+
+		var id int64
+		if l.opts.IDAttr != "" {
+			id, _ = strconv.ParseInt(e.GetAttributeValue(l.opts.IDAttr), 10, 64)
+		}
+		reg.add(id, name)
+	}
+
+	return reg, nil
+}
+
+// update refreshes the cached list if it's due, keeping the previous list if
+// the directory can't be reached.
+func (l *LDAPCorpMemberTracker) update() {
+	l.Lock()
+	defer l.Unlock()
+
+	if time.Since(l.lastUpdate) < l.opts.Refresh || time.Since(l.lastAttempt) < retryInterval {
+		return
+	}
+	l.lastAttempt = time.Now()
+
+	reg, err := l.search(l.opts.Filter)
+	if err != nil {
+		log.Printf("Error getting registered members from LDAP: %s", err)
+		l.lastErr = err
+		return
+	}
+
+	l.cached = reg
+	l.lastErr = nil
+	l.lastUpdate = time.Now()
+}
+
+func (l *LDAPCorpMemberTracker) GetRegistrations() (*Registrations, error) {
+	l.update()
+
+	l.RLock()
+	defer l.RUnlock()
+
+	if l.lastUpdate.IsZero() {
+		return nil, fmt.Errorf("registered members not fetched from LDAP yet: %v", l.lastErr)
+	}
+
+	reg := newRegistrations(l.cached.IDs != nil)
+	for k, v := range l.cached.Names {
+		reg.Names[k] = v
+	}
+	for k, v := range l.cached.IDs {
+		reg.IDs[k] = v
+	}
+	for k, v := range l.cached.idByName {
+		reg.idByName[k] = v
+	}
+
+	return reg, nil
+}
+
+func (l *LDAPCorpMemberTracker) GetMemberMap() (map[string]bool, error) {
+	reg, err := l.GetRegistrations()
+	if err != nil {
+		return nil, err
+	}
+	return reg.memberMap(), nil
+}
+
+// IsRegistered looks the character up directly, falling back to the cached
+// list if the directory can't be reached.
+func (l *LDAPCorpMemberTracker) IsRegistered(name string) (bool, error) {
+	filter := fmt.Sprintf("(&%s(%s=%s))", l.opts.Filter, l.opts.NameAttr, ldap.EscapeFilter(name))
+	reg, err := l.search(filter)
+	if err == nil {
+		_, ok := reg.Names[normalizeName(name)]
+		return ok, nil
+	}
+	log.Printf("LDAP registration check for %s failed, using cached list: %s", name, err)
+
+	l.update()
+
+	l.RLock()
+	defer l.RUnlock()
+
+	if l.lastUpdate.IsZero() {
+		return false, err
+	}
+
+	_, ok := l.cached.Names[normalizeName(name)]
+	return ok, nil
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ldapStandIn is a minimal in-process LDAP server answering simple binds and
+// subtree searches over a fixed set of entries.
+type ldapStandIn struct {
+	ln       net.Listener
+	bindDN   string
+	password string
+	entries  []ldapEntry
+
+	sync.Mutex
+	binds    []string
+	searches []string
+}
+
+type ldapEntry struct {
+	dn    string
+	attrs map[string]string
+}
+
+func newLDAPStandIn(t *testing.T, bindDN, password string, entries []ldapEntry) *ldapStandIn {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &ldapStandIn{ln: ln, bindDN: bindDN, password: password, entries: entries}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *ldapStandIn) url() string {
+	return "ldap://" + s.ln.Addr().String()
+}
+
+func (s *ldapStandIn) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		p, err := ber.ReadPacket(conn)
+		if err != nil || len(p.Children) < 2 {
+			return
+		}
+		id := p.Children[0].Value.(int64)
+		op := p.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			dn := op.Children[1].Value.(string)
+			password := op.Children[2].Data.String()
+			s.Lock()
+			s.binds = append(s.binds, dn)
+			s.Unlock()
+
+			code := ldap.LDAPResultSuccess
+			if dn != s.bindDN || password != s.password {
+				code = ldap.LDAPResultInvalidCredentials
+			}
+			conn.Write(ldapResponse(id, ldap.ApplicationBindResponse, code).Bytes())
+
+		case ldap.ApplicationSearchRequest:
+			base := op.Children[0].Value.(string)
+			filter, err := ldap.DecompileFilter(op.Children[6])
+			if err != nil {
+				return
+			}
+			s.Lock()
+			s.searches = append(s.searches, filter)
+			s.Unlock()
+
+			for _, e := range s.entries {
+				if !strings.HasSuffix(e.dn, base) || !ldapMatch(op.Children[6], e) {
+					continue
+				}
+				conn.Write(ldapEntryPacket(id, e).Bytes())
+			}
+			conn.Write(ldapResponse(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess).Bytes())
+
+		default:
+			return
+		}
+	}
+}
+
+func ldapMessage(id int64, op *ber.Packet) *ber.Packet {
+	p := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "MessageID"))
+	p.AppendChild(op)
+	return p
+}
+
+func ldapResponse(id int64, tag ber.Tag, code int) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Response")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "resultCode"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "matchedDN"))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "diagnosticMessage"))
+	return ldapMessage(id, op)
+}
+
+func ldapEntryPacket(id int64, e ldapEntry) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Entry")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.dn, "objectName"))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attributes")
+	for k, v := range e.attrs {
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, k, "type"))
+		vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "vals")
+		vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "value"))
+		attr.AppendChild(vals)
+		attrs.AppendChild(attr)
+	}
+	op.AppendChild(attrs)
+	return ldapMessage(id, op)
+}
+
+// ldapMatch evaluates the and, or, not, equality and presence filters the
+// tracker uses.  Attribute names and equality values are case-insensitive.
+func ldapMatch(f *ber.Packet, e ldapEntry) bool {
+	value := func(attr string) (string, bool) {
+		for k, v := range e.attrs {
+			if strings.EqualFold(k, attr) {
+				return v, true
+			}
+		}
+		return "", strings.EqualFold(attr, "objectClass")
+	}
+
+	switch f.Tag {
+	case ldap.FilterAnd:
+		for _, c := range f.Children {
+			if !ldapMatch(c, e) {
+				return false
+			}
+		}
+		return true
+	case ldap.FilterOr:
+		for _, c := range f.Children {
+			if ldapMatch(c, e) {
+				return true
+			}
+		}
+		return false
+	case ldap.FilterNot:
+		return !ldapMatch(f.Children[0], e)
+	case ldap.FilterEqualityMatch:
+		v, ok := value(f.Children[0].Value.(string))
+		return ok && strings.EqualFold(v, f.Children[1].Value.(string))
+	case ldap.FilterPresent:
+		_, ok := value(f.Data.String())
+		return ok
+	}
+	return false
+}
+
+var ldapTestEntries = []ldapEntry{
+	{"uid=1,ou=people,dc=corp", map[string]string{"cn": "Alice Alpha", "employeeNumber": "90001"}},
+	{"uid=2,ou=people,dc=corp", map[string]string{"cn": "Bob Bravo", "employeeNumber": "90002"}},
+	{"uid=3,ou=people,dc=corp", map[string]string{"cn": "Carol Charlie"}},
+	{"uid=4,ou=former,dc=corp", map[string]string{"cn": "Dave Delta", "employeeNumber": "90004"}},
+}
+
+// ldapTestDialer counts connections and can be switched off to simulate the
+// directory going away.
+type ldapTestDialer struct {
+	sync.Mutex
+	dials int
+	down  bool
+}
+
+func (d *ldapTestDialer) dial(url string) (*ldap.Conn, error) {
+	d.Lock()
+	defer d.Unlock()
+	if d.down {
+		return nil, errors.New("directory unreachable")
+	}
+	d.dials++
+	return ldap.DialURL(url)
+}
+
+func newTestLDAPTracker(t *testing.T, opts LDAPTrackerOptions) (*LDAPCorpMemberTracker, *ldapStandIn, *ldapTestDialer) {
+	s := newLDAPStandIn(t, "cn=slopemaker,dc=corp", "hunter2", ldapTestEntries)
+	d := &ldapTestDialer{}
+	opts.BindDN = "cn=slopemaker,dc=corp"
+	opts.BindPassword = "hunter2"
+	opts.Dial = d.dial
+	return NewLDAPCorpMemberTracker(s.url(), opts), s, d
+}
+
+func TestLDAPTrackerSearchesByName(t *testing.T) {
+	l, s, _ := newTestLDAPTracker(t, LDAPTrackerOptions{BaseDN: "ou=people,dc=corp"})
+
+	reg, err := l.GetRegistrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reg.Names) != 3 || reg.Names["alice alpha"] != "Alice Alpha" || reg.IDs != nil {
+		t.Errorf("Registrations = %v, %v, want the three people without IDs", reg.Names, reg.IDs)
+	}
+	if _, ok := reg.Names["dave delta"]; ok {
+		t.Errorf("Dave Delta is outside the base DN but was returned")
+	}
+
+	for name, want := range map[string]bool{"bob bravo": true, "Dave Delta": false, "Nobody": false} {
+		got, err := l.IsRegistered(name)
+		if err != nil || got != want {
+			t.Errorf("IsRegistered(%q) = %v, %v, want %v", name, got, err, want)
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	for _, dn := range s.binds {
+		if dn != "cn=slopemaker,dc=corp" {
+			t.Errorf("bound as %q, want the service DN", dn)
+		}
+	}
+	want := "(&(objectClass=*)(cn=bob bravo))"
+	var searched bool
+	for _, f := range s.searches {
+		searched = searched || f == want
+	}
+	if !searched {
+		t.Errorf("IsRegistered searches %q, want one for %q", s.searches, want)
+	}
+}
+
+func TestLDAPTrackerSearchesByID(t *testing.T) {
+	l, _, _ := newTestLDAPTracker(t, LDAPTrackerOptions{BaseDN: "dc=corp",
+		Filter: "(employeeNumber=*)", IDAttr: "employeeNumber"})
+
+	reg, err := l.GetRegistrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reg.Names) != 3 || reg.IDs[90004] != "Dave Delta" {
+		t.Errorf("Registrations = %v, %v, want the three numbered entries", reg.Names, reg.IDs)
+	}
+
+	// A renamed character still matches by ID.
+	registered, mismatch, _ := reg.match(MemberTrackingMember{CharacterID: 90002, Name: "Robert Bravo"})
+	if !registered || mismatch == "" {
+		t.Errorf("renamed character: registered %v, mismatch %q", registered, mismatch)
+	}
+
+	if ok, err := l.IsRegistered("Carol Charlie"); ok || err != nil {
+		t.Errorf("IsRegistered(Carol Charlie) = %v, %v, want false as she has no ID", ok, err)
+	}
+}
+
+func TestLDAPTrackerCaches(t *testing.T) {
+	l, _, d := newTestLDAPTracker(t, LDAPTrackerOptions{BaseDN: "ou=people,dc=corp"})
+
+	for i := 0; i < 3; i++ {
+		if _, err := l.GetRegistrations(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d.dials != 1 {
+		t.Errorf("dialled %d times for repeated lists, want 1", d.dials)
+	}
+
+	// With the directory down lookups fall back to the cached list.
+	d.down = true
+	if ok, err := l.IsRegistered("Alice Alpha"); !ok || err != nil {
+		t.Errorf("IsRegistered with directory down = %v, %v, want cached true", ok, err)
+	}
+	if _, err := l.GetRegistrations(); err != nil {
+		t.Errorf("GetRegistrations with directory down: %s, want cached list", err)
+	}
+}
+
+func TestLDAPTrackerErrors(t *testing.T) {
+	d := &ldapTestDialer{down: true}
+	l := NewLDAPCorpMemberTracker("ldap://127.0.0.1:1", LDAPTrackerOptions{Dial: d.dial})
+	if _, err := l.GetRegistrations(); err == nil {
+		t.Errorf("GetRegistrations succeeded without a directory")
+	}
+	if _, err := l.IsRegistered("Alice Alpha"); err == nil {
+		t.Errorf("IsRegistered succeeded without a directory")
+	}
+
+	s := newLDAPStandIn(t, "cn=slopemaker,dc=corp", "hunter2", ldapTestEntries)
+	l = NewLDAPCorpMemberTracker(s.url(), LDAPTrackerOptions{BindDN: "cn=slopemaker,dc=corp",
+		BindPassword: "wrong", BaseDN: "dc=corp"})
+	_, err := l.GetRegistrations()
+	if err == nil || !strings.Contains(err.Error(), "bind as cn=slopemaker,dc=corp") {
+		t.Errorf("GetRegistrations with a bad password = %v, want a bind error", err)
+	}
+}
+
+func TestCheckLDAP(t *testing.T) {
+	s := newLDAPStandIn(t, "cn=slopemaker,dc=corp", "hunter2", ldapTestEntries)
+
+	tests := []struct {
+		opts     LDAPTrackerOptions
+		problems int
+	}{
+		{LDAPTrackerOptions{BindDN: "cn=slopemaker,dc=corp", BindPassword: "hunter2", BaseDN: "dc=corp"}, 0},
+		{LDAPTrackerOptions{BindDN: "cn=slopemaker,dc=corp", BindPassword: "wrong", BaseDN: "dc=corp"}, 1},
+		{LDAPTrackerOptions{BindDN: "cn=slopemaker,dc=corp", BindPassword: "hunter2", BaseDN: "ou=nobody,dc=corp"}, 1},
+	}
+	for _, tt := range tests {
+		cerr := &ConfigError{File: "test.conf"}
+		checkLDAP(cerr, "registered_characters", s.url(), tt.opts)
+		if len(cerr.Problems) != tt.problems {
+			t.Errorf("checkLDAP(%+v) problems %q, want %d", tt.opts, cerr.Problems, tt.problems)
+		}
+	}
+}
@@ -1,26 +1,21 @@
 package main
 
 import (
-	"html/template"
-	"log"
 	"net/http"
 	"strings"
 )
 
 func forceLogin(w http.ResponseWriter, req *http.Request, ses Session) {
 	if ses.Get("username") == "" {
-		w.Header().Set("Location", "login")
+		// Stay relative so the app can live under a sub-path, but climb
+		// out of nested pages like member/123.
+		up := strings.Repeat("../", strings.Count(strings.TrimPrefix(req.URL.Path, "/"), "/"))
+		w.Header().Set("Location", up+"login")
 		w.WriteHeader(http.StatusFound)
 	}
 }
 
 func displayLogin(w http.ResponseWriter, req *http.Request, ses Session) {
-	loginTemplate, err := template.New("base.html").ParseFiles("templates/base.html", "templates/login.html")
-	if err != nil {
-		log.Printf("Template error: %s", err)
-		return
-	}
-
 	type loginData struct {
 		Title string
 		Error string
@@ -28,10 +23,7 @@ func displayLogin(w http.ResponseWriter, req *http.Request, ses Session) {
 	data := loginData{Error: ses.Get("loginError"), Title: "SlopeMaker Login"}
 	ses.Set("loginError", "")
 
-	err = loginTemplate.Execute(w, data)
-	if err != nil {
-		log.Printf("Template Error: %s", err)
-	}
+	renderPage(w, "login", data)
 }
 
 func handleLogin(w http.ResponseWriter, req *http.Request, ses Session) {
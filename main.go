@@ -1,13 +1,14 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"flag"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -15,15 +16,10 @@ import (
 
 	"github.com/boltdb/bolt"
 	"github.com/codegangsta/martini"
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/inominate/session"
-	"github.com/robfig/config"
 )
 
 var sesManager *session.SessionManager
-var conf *config.Config
-var exemptRoles []int64
-var exemptChars []string
 
 func setupMartini() *martini.ClassicMartini {
 	r := martini.NewRouter()
@@ -36,55 +32,60 @@ func setupMartini() *martini.ClassicMartini {
 	return &martini.ClassicMartini{m, r}
 }
 
-func loadConfig() error {
-	newConf, err := config.ReadDefault("purger.conf")
-	if err != nil {
-		return err
-	}
-	conf = newConf
+var bdb *bolt.DB
 
-	confStr, err := conf.String("purger", "exemptCharacters")
-	if err == nil {
-		exemptChars = strings.Split(strings.ToLower(confStr), ",")
-		for k := range exemptChars {
-			exemptChars[k] = strings.TrimSpace(exemptChars[k])
-		}
-	} else {
-		log.Printf("No exempted characters found: %s", err)
-		exemptChars = []string{}
-	}
-
-	confStr, err = conf.String("purger", "exemptRoles")
-	if err == nil {
-		exemptRolesStrings := strings.Split(confStr, ",")
-		exemptRoles = make([]int64, len(exemptRolesStrings))
-		for k, v := range exemptRolesStrings {
-			v = strings.TrimSpace(v)
-			if v == "" {
-				continue
-			}
-
-			role, err := strconv.ParseInt(v, 10, 64)
-			if err != nil || role == 0 || role < 0 {
-				log.Printf("Error parsing role '%s': %s", v, err)
-				continue
-			}
-
-			exemptRoles[k] = role
-		}
-	} else {
-		log.Printf("No exempted roles found: %s", err)
-		exemptRoles = []int64{}
-	}
+// stringList is a flag.Value collecting every use of a repeated flag.
+type stringList []string
 
+func (s *stringList) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
 	return nil
 }
 
-var bdb *bolt.DB
+// shutdown stops accepting requests, waits for the member updater and any
+// pending saves to finish, then flushes state and closes the database.
+func shutdown(srv *http.Server, stopUpdater context.CancelFunc, updaterWG *sync.WaitGroup) {
+	log.Printf("Shutting down.")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err := srv.Shutdown(ctx)
+	if err != nil {
+		log.Printf("Failed to stop web server cleanly: %s", err)
+	}
+
+	stopUpdater()
+	updaterWG.Wait()
+	saveWG.Wait()
+	saveState()
+
+	err = bdb.Close()
+	if err != nil {
+		log.Printf("Failed to close db: %s", err)
+	}
+}
 
 func main() {
 	var err error
 
+	if env := os.Getenv(envPrefix + "CONFIG"); env != "" {
+		configFile = env
+	}
+	flag.StringVar(&configFile, "config", configFile, "path to the config file")
+	flag.Var((*stringList)(&setFlags), "set", "override a config option as section.key=value, repeatable")
+	flag.Parse()
+
+	switch flag.Arg(0) {
+	case "check-config":
+		os.Exit(checkConfig(flag.Args()[1:]))
+	case "export":
+		os.Exit(exportCommand(flag.Args()[1:]))
+	}
+
 	err = loadConfig()
 	if err != nil {
 		log.Fatalf("Failed to load config: %s", err)
@@ -99,10 +100,6 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to load config: %s", err)
 	}
-	days, err := c.Int("purger", "maxIdleDays")
-	if err != nil {
-		log.Fatalf("Failed to load config: %s", err)
-	}
 	listen, err := c.String("purger", "listen")
 	if err != nil {
 		log.Fatalf("Failed to load config: %s", err)
@@ -118,30 +115,9 @@ func main() {
 	apiClient := apicache.NewClient(apicache.NilCache)
 	apiClient.BaseURL = baseURL
 
-	var memberDB *sql.DB
-	var query string
-	var cmt CorpMemberTracker
-
-	memberDSN, _ := c.String("registered_characters", "DSN")
-	memberURL, _ := c.String("registered_characters", "URL")
-	if memberDSN != "" {
-		memberDB, err = sql.Open("mysql", memberDSN)
-		if err != nil {
-			log.Fatalf("could not open database: %s", err)
-		}
-
-		query, _ = c.String("registered_characters", "all_query")
-		if query == "" {
-			log.Fatalf("registered_characters dsn specified but no all_query found.")
-		}
-
-		query, _ = c.String("registered_characters", "single_query")
-		if query == "" {
-			log.Fatalf("registered_characters dsn specified but no single_query found.")
-		}
-		cmt = NewSQLCorpMemberTracker(memberDB)
-	} else if memberURL != "" {
-		cmt = NewHTTPCorpMemberTracker(memberURL)
+	cmt, err := newTrackerFromConfig(c)
+	if err != nil {
+		log.Fatalf("Failed to set up registered characters: %s", err)
 	}
 
 	var store session.SessionStorage
@@ -159,6 +135,20 @@ func main() {
 	sesManager, _ = session.NewSessionManager(store, "slopemaker_session")
 
 	loadState()
+	loadExemptions()
+	loadManualTargets()
+
+	err = bdb.Update(indexHistory)
+	if err != nil {
+		log.Printf("Failed to index roster history: %s", err)
+	}
+
+	themeDir, _ := c.String("purger", "themeDir")
+	dev, _ := c.Bool("purger", "devMode")
+	err = setupAssets(themeDir, dev)
+	if err != nil {
+		log.Fatalf("Failed to load templates: %s", err)
+	}
 
 	m := setupMartini()
 
@@ -171,25 +161,71 @@ func main() {
 	m.Get("/boot", forceLogin, handleBoot)
 	m.Post("/boot", forceLogin, handleBoot)
 
+	m.Post("/undo", forceLogin, handleUndo)
+
+	m.Get("/pending", forceLogin, handlePending)
+
+	m.Get("/exemptions", forceLogin, handleExemptions)
+	m.Post("/exemptions", forceLogin, handleExemptions)
+
+	m.Get("/import", forceLogin, handleImport)
+	m.Post("/import", forceLogin, handleImport)
+
+	m.Get("/audit", forceLogin, handleAudit)
+
+	m.Get("/members", forceLogin, handleMembers)
+	m.Get("/member/:id", forceLogin, handleMember)
+	m.Post("/member/:id", forceLogin, handleMember)
+
+	m.Get("/history", forceLogin, handleHistory)
+
+	m.Get("/analytics", forceLogin, handleAnalytics)
+	m.Get("/analytics.json", forceLogin, handleAnalyticsJSON)
+
+	m.Get("/export/queue", forceLogin, handleExportQueue)
+	m.Get("/export/completed", forceLogin, handleExportCompleted)
+
 	m.Get("/stats", forceLogin, handleStats)
 
+	m.Post("/admin/reload", forceLogin, handleReload)
+
 	m.Get("/login", displayLogin)
 	m.Post("/login", handleLogin)
 
-	m.Use(martini.Static("static", martini.StaticOptions{Prefix: "static/"}))
-
-	go membersUpdater(apiClient, int64(keyid), vcode, time.Duration(days)*time.Hour*24, cmt)
-	go http.ListenAndServe(listen, m)
+	m.Get("/static/**", handleStatic)
+
+	ctx, stopUpdater := context.WithCancel(context.Background())
+	var updaterWG sync.WaitGroup
+	updaterWG.Add(1)
+	go func() {
+		defer updaterWG.Done()
+		membersUpdater(ctx, apiClient, int64(keyid), vcode, cmt)
+	}()
+
+	srv := &http.Server{Addr: listen, Handler: m}
+	go func() {
+		err := srv.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Failed to listen on %s: %s", listen, err)
+		}
+	}()
 
 	sch := make(chan os.Signal, 1)
-	signal.Notify(sch, syscall.SIGHUP)
-	for _ = range sch {
+	signal.Notify(sch, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	for sig := range sch {
+		if sig != syscall.SIGHUP {
+			break
+		}
+
 		saveState()
-		err = loadConfig()
+		_, _, err = reloadConfig()
 		if err != nil {
-			log.Printf("Failed to reload config: %s", err)
+			log.Printf("Failed to reload config, keeping previous configuration: %s", err)
 			continue
 		}
 		log.Printf("Reloaded user configuration.")
 	}
+
+	signal.Stop(sch)
+	shutdown(srv, stopUpdater, &updaterWG)
 }
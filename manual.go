@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// manualTarget is a character leadership has decided to remove whatever
+// the rules say, such as a spy.
+type manualTarget struct {
+	CharacterID int64  `json:"characterID"`
+	Name        string `json:"name"`
+	Reason      string `json:"reason"`
+	Priority    int    `json:"priority"`
+
+	AddedBy string    `json:"-"`
+	Added   time.Time `json:"-"`
+}
+
+// manualTargets are keyed by normalized character name.  Protected by
+// purgeLock.
+var manualTargets = map[string]*manualTarget{}
+
+// manualTargetFor finds any manual target matching mt.  Must be called with
+// purgeLock held.
+func manualTargetFor(mt MemberTrackingMember) *manualTarget {
+	if t, ok := manualTargets[normalizeName(mt.Name)]; ok {
+		return t
+	}
+	for _, t := range manualTargets {
+		if t.CharacterID != 0 && t.CharacterID == mt.CharacterID {
+			return t
+		}
+	}
+	return nil
+}
+
+// queueOrder lists queued character IDs, highest priority first.  Within a
+// priority the order is random so operators don't all pick the same members.
+// Must be called with purgeLock held.
+func queueOrder() []int64 {
+	ids := make([]int64, 0, len(toBePurged))
+	for id := range toBePurged {
+		ids = append(ids, id)
+	}
+	sort.SliceStable(ids, func(i, j int) bool {
+		return toBePurged[ids[i]].Priority > toBePurged[ids[j]].Priority
+	})
+	return ids
+}
+
+func loadManualTargets() {
+	purgeLock.Lock()
+	defer purgeLock.Unlock()
+
+	err := bdb.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("manual"))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var t manualTarget
+			err := gob.NewDecoder(bytes.NewReader(v)).Decode(&t)
+			if err != nil {
+				return err
+			}
+			manualTargets[string(k)] = &t
+			return nil
+		})
+	})
+	if err != nil {
+		log.Printf("Failed to load manual targets: %s", err)
+	}
+}
+
+// saveManualTargets stores targets, deleting those keyed by remove.
+func saveManualTargets(add map[string]*manualTarget, remove []string) error {
+	return bdb.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("manual"))
+		if err != nil {
+			return err
+		}
+
+		for key, t := range add {
+			buf := &bytes.Buffer{}
+			err = gob.NewEncoder(buf).Encode(t)
+			if err != nil {
+				return err
+			}
+			err = b.Put([]byte(key), buf.Bytes())
+			if err != nil {
+				return err
+			}
+		}
+		for _, key := range remove {
+			err = b.Delete([]byte(key))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// parseManualCSV reads lines of name, reason and priority, with the name
+// optionally preceded by a character ID, in which case the name may be left
+// out.  Missing reasons and priorities are taken from the defaults.  A header
+// line is skipped.
+func parseManualCSV(r io.Reader, reason string, priority int) ([]*manualTarget, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	var targets []*manualTarget
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(rec) == 0 || strings.TrimSpace(rec[0]) == "" {
+			continue
+		}
+
+		t := &manualTarget{Reason: reason, Priority: priority}
+		if id, err := strconv.ParseInt(rec[0], 10, 64); err == nil {
+			t.CharacterID = id
+			rec = rec[1:]
+		} else if line == 1 && (strings.EqualFold(rec[0], "name") || strings.EqualFold(rec[0], "characterID")) {
+			continue
+		}
+
+		if len(rec) > 0 {
+			t.Name = strings.TrimSpace(rec[0])
+		}
+		if len(rec) > 1 && strings.TrimSpace(rec[1]) != "" {
+			t.Reason = strings.TrimSpace(rec[1])
+		}
+		if len(rec) > 2 && strings.TrimSpace(rec[2]) != "" {
+			t.Priority, err = strconv.Atoi(strings.TrimSpace(rec[2]))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: priority '%s' is not a number", line, rec[2])
+			}
+		}
+		if t.Name == "" && t.CharacterID == 0 {
+			return nil, fmt.Errorf("line %d: no character name or ID", line)
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// rosterMember finds a character in the last pull by ID, or by name if the
+// ID is 0 or not found.  Must be called with purgeLock held.
+func rosterMember(id int64, name string) *MemberTrackingMember {
+	var byName *MemberTrackingMember
+	for i := range lastPull.members {
+		mt := &lastPull.members[i]
+		if id != 0 && mt.CharacterID == id {
+			return mt
+		}
+		if byName == nil && name != "" && normalizeName(mt.Name) == normalizeName(name) {
+			byName = mt
+		}
+	}
+	return byName
+}
+
+// importManualTargets adds targets to the list, replacing any existing entry
+// for the same character.  Targets given only by ID must be in corp.  Must be
+// called with purgeLock held.
+func importManualTargets(targets []*manualTarget, username string) error {
+	add := map[string]*manualTarget{}
+	for i, t := range targets {
+		if t == nil {
+			return fmt.Errorf("entry %d is empty", i+1)
+		}
+		t.Name = strings.TrimSpace(t.Name)
+
+		// Use the proper spelling and character ID if they're in corp.
+		if mt := rosterMember(t.CharacterID, t.Name); mt != nil {
+			t.Name = mt.Name
+			t.CharacterID = mt.CharacterID
+		}
+
+		if t.Name == "" {
+			if t.CharacterID == 0 {
+				return fmt.Errorf("entry %d has no name or character ID", i+1)
+			}
+			return fmt.Errorf("character %d is not in corp, give a name as well", t.CharacterID)
+		}
+		if t.Reason == "" {
+			return fmt.Errorf("%s has no reason", t.Name)
+		}
+		t.AddedBy = username
+		t.Added = time.Now()
+
+		add[normalizeName(t.Name)] = t
+	}
+
+	err := saveManualTargets(add, nil)
+	if err != nil {
+		return err
+	}
+
+	for key, t := range add {
+		manualTargets[key] = t
+		log.Printf("%s added %s to the manual kick list: %s", username, t.Name, t.Reason)
+		recordAudit(username, "manually queued", t.CharacterID, t.Name,
+			fmt.Sprintf("Priority %d: %s", t.Priority, t.Reason))
+	}
+	return nil
+}
+
+// updateManualTargets imports targets and removes the entry keyed by remove,
+// if any.
+func updateManualTargets(targets []*manualTarget, remove, username string) error {
+	purgeLock.Lock()
+	defer purgeLock.Unlock()
+
+	if len(targets) > 0 {
+		err := importManualTargets(targets, username)
+		if err != nil {
+			return err
+		}
+	}
+
+	if t, ok := manualTargets[remove]; ok {
+		err := saveManualTargets(nil, []string{remove})
+		if err != nil {
+			return err
+		}
+		delete(manualTargets, remove)
+		log.Printf("%s removed %s from the manual kick list.", username, t.Name)
+		recordAudit(username, "manual queue removed", t.CharacterID, t.Name, t.Reason)
+	}
+	return nil
+}
+
+// handleImport shows the manual kick list and takes additions as a CSV
+// upload, pasted CSV, or a JSON list of targets posted as application/json.
+func handleImport(w http.ResponseWriter, r *http.Request, ses Session) {
+	username := ses.Get("username")
+
+	if r.Method == "POST" {
+		if !isAdmin(username) {
+			http.Error(w, "Not authorized.", http.StatusForbidden)
+			return
+		}
+
+		var targets []*manualTarget
+		var remove string
+		var err error
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			err = json.NewDecoder(r.Body).Decode(&targets)
+			for i, t := range targets {
+				if t == nil && err == nil {
+					err = fmt.Errorf("entry %d is null", i+1)
+				}
+			}
+		} else {
+			r.ParseMultipartForm(1 << 20)
+			remove = r.FormValue("remove")
+
+			priority, _ := strconv.Atoi(r.FormValue("priority"))
+			reason := strings.TrimSpace(r.FormValue("reason"))
+
+			var list io.Reader = strings.NewReader(r.FormValue("list"))
+			if f, _, ferr := r.FormFile("file"); ferr == nil {
+				defer f.Close()
+				list = f
+			}
+			if remove == "" {
+				targets, err = parseManualCSV(list, reason, priority)
+			}
+		}
+
+		if err == nil {
+			err = updateManualTargets(targets, remove, username)
+		}
+
+		if err == nil {
+			reevaluateRoster()
+		}
+
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			fmt.Fprintf(w, "Imported %d characters.\n", len(targets))
+			return
+		}
+
+		if err != nil {
+			ses.Set("importError", err.Error())
+		}
+		w.Header().Set("Location", "import")
+		w.WriteHeader(http.StatusFound)
+		return
+	}
+
+	type manualRow struct {
+		Key string
+		*manualTarget
+	}
+	type ImportData struct {
+		Title   string
+		Error   string
+		CanEdit bool
+		Rows    []manualRow
+	}
+	id := ImportData{Title: "Manual Kick List", Error: ses.Get("importError"),
+		CanEdit: isAdmin(username)}
+	ses.Set("importError", "")
+
+	purgeLock.RLock()
+	for k, t := range manualTargets {
+		id.Rows = append(id.Rows, manualRow{k, t})
+	}
+	purgeLock.RUnlock()
+	sort.Slice(id.Rows, func(i, j int) bool {
+		if id.Rows[i].Priority != id.Rows[j].Priority {
+			return id.Rows[i].Priority > id.Rows[j].Priority
+		}
+		return id.Rows[i].Name < id.Rows[j].Name
+	})
+
+	renderPage(w, "import", id)
+}
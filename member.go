@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/codegangsta/martini"
+)
+
+// memberSnapshot is how a character looked in a stored roster pull.  Only
+// pulls where something changed are kept.
+type memberSnapshot struct {
+	Pulled    time.Time
+	Title     string
+	Roles     string
+	LastLogin time.Time
+	Location  string
+	ShipType  string
+}
+
+// memberEvent is an entry on a character's timeline, from either the audit
+// log or the roster history.
+type memberEvent struct {
+	Time   time.Time
+	Kind   string
+	User   string
+	Detail string
+}
+
+// The membersnaps and memberchanges buckets index the roster history by
+// character, keyed by character ID then pull time, so a character's page
+// doesn't have to decode every stored roster.  membersnaps holds a
+// character's entry whenever their title, roles or last login change, and
+// as they leave; memberchanges holds their share of the changes bucket.
+
+func memberPrefix(id int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(id))
+	return key
+}
+
+func memberKey(id int64, t time.Time) []byte {
+	return append(memberPrefix(id), historyKey(t)...)
+}
+
+func putGob(b *bolt.Bucket, key []byte, v interface{}) error {
+	buf := &bytes.Buffer{}
+	err := gob.NewEncoder(buf).Encode(v)
+	if err != nil {
+		return err
+	}
+	return b.Put(key, buf.Bytes())
+}
+
+// indexRoster records the entries in cur that changed since old, and those
+// of everyone who left.  Role changes are ignored if cur's roles are stale.
+func indexRoster(tx *bolt.Tx, pulled time.Time, old, cur []MemberTrackingMember, staleRoles bool) error {
+	b, err := tx.CreateBucketIfNotExists([]byte("membersnaps"))
+	if err != nil {
+		return err
+	}
+
+	oldByID := make(map[int64]MemberTrackingMember, len(old))
+	for _, mt := range old {
+		oldByID[mt.CharacterID] = mt
+	}
+
+	for _, mt := range cur {
+		prev, ok := oldByID[mt.CharacterID]
+		delete(oldByID, mt.CharacterID)
+		if ok && prev.Title == mt.Title && (staleRoles || memberRoles(prev) == memberRoles(mt)) &&
+			prev.LogonDateTime.Equal(mt.LogonDateTime.Time) {
+			continue
+		}
+		err = putGob(b, memberKey(mt.CharacterID, pulled), mt)
+		if err != nil {
+			return err
+		}
+	}
+
+	for id, mt := range oldByID {
+		err = putGob(b, memberKey(id, pulled), mt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexChanges files a pull's roster changes under each character.
+func indexChanges(tx *bolt.Tx, changes []rosterChange) error {
+	b, err := tx.CreateBucketIfNotExists([]byte("memberchanges"))
+	if err != nil {
+		return err
+	}
+
+	byID := map[int64][]rosterChange{}
+	for _, c := range changes {
+		byID[c.CharacterID] = append(byID[c.CharacterID], c)
+	}
+	for id, cs := range byID {
+		err = putGob(b, memberKey(id, cs[0].Time), cs)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexHistory builds the per-character buckets from the stored history if
+// they don't exist yet.
+func indexHistory(tx *bolt.Tx) error {
+	if tx.Bucket([]byte("membersnaps")) != nil {
+		return nil
+	}
+	if _, err := tx.CreateBucket([]byte("membersnaps")); err != nil {
+		return err
+	}
+
+	if b := tx.Bucket([]byte("snapshots")); b != nil {
+		var old []MemberTrackingMember
+		err := b.ForEach(func(k, v []byte) error {
+			members, err := decodeSnapshot(v)
+			if err != nil {
+				return err
+			}
+			err = indexRoster(tx, historyTime(k), old, members, false)
+			old = members
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if b := tx.Bucket([]byte("changes")); b != nil {
+		return b.ForEach(func(k, v []byte) error {
+			var changes []rosterChange
+			err := gob.NewDecoder(bytes.NewReader(v)).Decode(&changes)
+			if err != nil {
+				return err
+			}
+			return indexChanges(tx, changes)
+		})
+	}
+	return nil
+}
+
+// pruneMemberHistory drops index entries from before cutoff.  The last
+// entry before cutoff of anyone still in corp, cur, is kept as it still
+// describes them.
+func pruneMemberHistory(tx *bolt.Tx, cutoff time.Time, cur []MemberTrackingMember) error {
+	inCorp := make(map[int64]bool, len(cur))
+	for _, mt := range cur {
+		inCorp[mt.CharacterID] = true
+	}
+	min := historyKey(cutoff)
+
+	var stale [][]byte
+	if b := tx.Bucket([]byte("membersnaps")); b != nil {
+		c := b.Cursor()
+		k, _ := c.First()
+		for k != nil {
+			next, _ := c.Next()
+			if bytes.Compare(k[8:], min) < 0 {
+				superseded := next != nil && bytes.Equal(next[:8], k[:8]) &&
+					bytes.Compare(next[8:], min) <= 0
+				lastSeen := next == nil || !bytes.Equal(next[:8], k[:8])
+				id := int64(binary.BigEndian.Uint64(k))
+				if superseded || (lastSeen && !inCorp[id]) {
+					stale = append(stale, k)
+				}
+			}
+			k = next
+		}
+		for _, k := range stale {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+	}
+
+	stale = nil
+	if b := tx.Bucket([]byte("memberchanges")); b != nil {
+		b.ForEach(func(k, v []byte) error {
+			if bytes.Compare(k[8:], min) < 0 {
+				stale = append(stale, k)
+			}
+			return nil
+		})
+		for _, k := range stale {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// eachMemberEntry calls fn with each entry stored for a character in the
+// named bucket, oldest first.
+func eachMemberEntry(bucket string, id int64, fn func(t time.Time, v []byte) error) error {
+	return bdb.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+
+		prefix := memberPrefix(id)
+		c := b.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			err := fn(historyTime(k[8:]), v)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// memberHistory returns the stored roster history for a character, newest
+// first, along with how it looked in the last pull it was seen in.
+func memberHistory(id int64) (snaps []memberSnapshot, last *MemberTrackingMember, err error) {
+	err = eachMemberEntry("membersnaps", id, func(pulled time.Time, v []byte) error {
+		var mt MemberTrackingMember
+		err := gob.NewDecoder(bytes.NewReader(v)).Decode(&mt)
+		if err != nil {
+			return err
+		}
+
+		s := memberSnapshot{pulled, mt.Title, memberRoles(mt).String(),
+			mt.LogonDateTime.Time, mt.Location, mt.ShipType}
+		if n := len(snaps); n == 0 || snaps[n-1].Title != s.Title ||
+			snaps[n-1].Roles != s.Roles || !snaps[n-1].LastLogin.Equal(s.LastLogin) {
+			snaps = append(snaps, s)
+		}
+		last = &mt
+		return nil
+	})
+
+	for i, j := 0, len(snaps)-1; i < j; i, j = i+1, j-1 {
+		snaps[i], snaps[j] = snaps[j], snaps[i]
+	}
+	return snaps, last, err
+}
+
+// memberTimeline merges a character's audit events and roster changes,
+// newest first.
+func memberTimeline(id int64) ([]memberEvent, error) {
+	var events []memberEvent
+	for _, e := range readAudit(int(^uint(0)>>1), func(e *auditEvent) bool { return e.CharacterID == id }) {
+		events = append(events, memberEvent{e.Time, e.Action, e.User, e.Detail})
+	}
+
+	err := eachMemberEntry("memberchanges", id, func(_ time.Time, v []byte) error {
+		var changes []rosterChange
+		err := gob.NewDecoder(bytes.NewReader(v)).Decode(&changes)
+		for _, c := range changes {
+			events = append(events, memberEvent{c.Time, c.Kind, "", c.Detail})
+		}
+		return err
+	})
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.After(events[j].Time) })
+	return events, err
+}
+
+// claimMember hands a single queued member to username on the strip or boot
+// page, as the claim loops would.  Must be called with purgeLock held.
+func claimMember(id int64, username string, ses Session) (page string, ok bool) {
+	m, queued := toBePurged[id]
+	if !queued || m.Purged || time.Since(m.Stripped) <= 24*time.Hour {
+		return "", false
+	}
+	if time.Since(m.Claimed) <= time.Hour && m.ClaimedBy != username {
+		return "", false
+	}
+	if !m.actionable() || m.recheckRegistration() {
+		return "", false
+	}
+
+	page = "boot"
+	if m.Roles {
+		page = "strip"
+	}
+	key := page + "_victims"
+
+	victims := strToVictims(ses.Get(key))
+	for _, vid := range victims {
+		if vid == id {
+			return page, true
+		}
+	}
+
+	m.Claimed = time.Now()
+	m.ClaimedBy = username
+	ses.Set(key, victimsToStr(append(victims, id)))
+	return page, true
+}
+
+// registrationStatus describes mt's registration as of the last pull.  Must
+// be called with purgeLock held.
+func registrationStatus(mt MemberTrackingMember) (status, source, mismatch string) {
+	switch {
+	case lastPull.cmt == nil:
+		return "Not checked", "", ""
+	case lastPull.registered == nil:
+		return "Unknown", "", ""
+	}
+
+	registered, mismatch, source := lastPull.registered.match(mt)
+	if registered {
+		return "Registered", source, mismatch
+	}
+	return "Unregistered", source, mismatch
+}
+
+func handleMember(w http.ResponseWriter, r *http.Request, params martini.Params, ses Session) {
+	id, err := strconv.ParseInt(params["id"], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	username := ses.Get("username")
+
+	if r.Method == "POST" {
+		r.ParseForm()
+
+		if r.PostFormValue("action") == "claim" {
+			purgeLock.Lock()
+			page, ok := claimMember(id, username, ses)
+			if ok {
+				queueSave()
+			}
+			purgeLock.Unlock()
+
+			if ok {
+				w.Header().Set("Location", "../"+page)
+				w.WriteHeader(http.StatusFound)
+				return
+			}
+			ses.Set("memberError", "This character can't be claimed right now.")
+		}
+
+		w.Header().Set("Location", params["id"])
+		w.WriteHeader(http.StatusFound)
+		return
+	}
+
+	type MemberData struct {
+		Title string
+		Error string
+		ID    int64
+		Name  string
+
+		// Member is the latest known MemberTracking entry, InCorp whether
+		// it's from the current roster.
+		Member   *MemberTrackingMember
+		InCorp   bool
+		RoleSets roleSets
+
+		Registration string
+		RegisteredBy string
+		Mismatch     string
+		PendingDays  int
+
+		Excluded  bool
+		Exemption *exemption
+		Manual    *manualTarget
+
+		Queued   *purgeMember
+		State    string
+		CanClaim bool
+		CanEdit  bool
+
+		Snapshots []memberSnapshot
+		Events    []memberEvent
+	}
+	md := MemberData{ID: id, Error: ses.Get("memberError"), CanEdit: isAdmin(username)}
+	ses.Set("memberError", "")
+
+	snaps, last, err := memberHistory(id)
+	if err != nil {
+		log.Printf("Failed to read roster history for %d: %s", id, err)
+	}
+	md.Snapshots = snaps
+	md.Events, err = memberTimeline(id)
+	if err != nil {
+		log.Printf("Failed to read roster changes for %d: %s", id, err)
+	}
+
+	purgeLock.RLock()
+	for i := range lastPull.members {
+		if lastPull.members[i].CharacterID == id {
+			mt := lastPull.members[i]
+			md.Member, md.InCorp = &mt, true
+			break
+		}
+	}
+	if md.Member == nil {
+		md.Member = last
+	}
+
+	if mt := md.Member; mt != nil {
+		md.Name = mt.Name
+		md.RoleSets = memberRoles(*mt)
+
+		md.Registration, md.RegisteredBy, md.Mismatch = registrationStatus(*mt)
+		for _, p := range pendingMembers {
+			if p.Id == id {
+				md.PendingDays = p.DaysLeft
+			}
+		}
+
+		md.Exemption, _ = exemptedUntil(*mt)
+		md.Excluded = exempt(*mt) && md.Exemption == nil
+		md.Manual = manualTargetFor(*mt)
+	} else {
+		for _, t := range manualTargets {
+			if t.CharacterID == id {
+				md.Manual = t
+				md.Name = t.Name
+			}
+		}
+	}
+
+	if m, ok := toBePurged[id]; ok {
+		queued := *m
+		md.Queued = &queued
+		md.State = m.state()
+		md.CanClaim = md.State == "to strip" || md.State == "to kick" ||
+			(md.State == "claimed" && m.ClaimedBy == username)
+	}
+	purgeLock.RUnlock()
+
+	// Characters that left before history was kept may only be in the
+	// audit log.
+	if md.Name == "" {
+		for _, e := range readAudit(1, func(e *auditEvent) bool { return e.CharacterID == id }) {
+			md.Name = e.Name
+		}
+	}
+	if md.Name == "" {
+		http.NotFound(w, r)
+		return
+	}
+	md.Title = md.Name
+
+	renderPage(w, "member", md)
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memberRow is a character's entry in the roster index.  Everything is
+// copied so the index can be searched without purgeLock.
+type memberRow struct {
+	ID           int64
+	Name         string
+	Title        string
+	Joined       time.Time
+	LastLogin    time.Time
+	Roles        string
+	RoleHolder   bool
+	Registration string
+	Exempt       bool
+	InCorp       bool
+
+	// Queued is a copy of the purge queue entry, if any, so the state can be
+	// worked out at search time.
+	Queued *purgeMember
+
+	lowerName string
+}
+
+// State is the row's purge state, "exempt", or "" for members left alone.
+func (r *memberRow) State() string {
+	if r.Queued != nil {
+		return r.Queued.state()
+	}
+	if r.Exempt {
+		return "exempt"
+	}
+	return ""
+}
+
+func (r *memberRow) Reason() string {
+	if r.Queued == nil {
+		return ""
+	}
+	return strings.TrimSpace(r.Queued.Reason)
+}
+
+func (r *memberRow) IdleDays() int {
+	return int(time.Since(r.LastLogin) / (24 * time.Hour))
+}
+
+// memberIndex is the roster and purge queue flattened for searching.  It is
+// rebuilt whenever the state is saved and has its own lock.
+var memberIndex struct {
+	sync.RWMutex
+	rows  []memberRow
+	built time.Time
+}
+
+// updateMemberIndex rebuilds the roster index.  Must be called with purgeLock
+// held.
+func updateMemberIndex() {
+	pending := map[int64]bool{}
+	for _, p := range pendingMembers {
+		pending[p.Id] = true
+	}
+
+	rows := make([]memberRow, 0, len(lastPull.members))
+	seen := map[int64]bool{}
+	for _, mt := range lastPull.members {
+		rs := memberRoles(mt)
+		r := memberRow{ID: mt.CharacterID, Name: mt.Name, Title: mt.Title,
+			Joined: mt.StartDateTime.Time, LastLogin: mt.LogonDateTime.Time,
+			Roles: rs.String(), RoleHolder: rs.any(), Exempt: exempt(mt), InCorp: true}
+		r.Registration, _, _ = registrationStatus(mt)
+		if pending[mt.CharacterID] {
+			r.Registration = "Pending"
+		}
+		if m, ok := toBePurged[mt.CharacterID]; ok {
+			queued := *m
+			r.Queued = &queued
+		}
+		rows = append(rows, r)
+		seen[mt.CharacterID] = true
+	}
+
+	// Queued members no longer in the roster, typically those kicked.
+	for id, m := range toBePurged {
+		if seen[id] {
+			continue
+		}
+		queued := *m
+		rows = append(rows, memberRow{ID: id, Name: m.Name, Title: m.Title,
+			Joined: m.Joined, LastLogin: m.LastLogin, Roles: m.RoleSets.String(),
+			RoleHolder: m.Roles, Queued: &queued})
+	}
+
+	for i := range rows {
+		rows[i].lowerName = strings.ToLower(rows[i].Name)
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].lowerName < rows[j].lowerName })
+
+	memberIndex.Lock()
+	memberIndex.rows = rows
+	memberIndex.built = time.Now()
+	memberIndex.Unlock()
+}
+
+// memberQuery is a search of the roster index.
+type memberQuery struct {
+	Name         string
+	State        string
+	Reason       string
+	RoleHolder   string
+	MinIdle      string
+	MaxIdle      string
+	Registration string
+	Sort         string
+	Desc         bool
+	Page         int
+}
+
+const membersPerPage = 50
+
+func parseMemberQuery(r *http.Request) memberQuery {
+	q := memberQuery{
+		Name:         strings.TrimSpace(r.FormValue("name")),
+		State:        r.FormValue("state"),
+		Reason:       strings.TrimSpace(r.FormValue("reason")),
+		RoleHolder:   r.FormValue("roles"),
+		MinIdle:      r.FormValue("minIdle"),
+		MaxIdle:      r.FormValue("maxIdle"),
+		Registration: r.FormValue("registration"),
+		Sort:         r.FormValue("sort"),
+		Desc:         r.FormValue("desc") != "",
+	}
+	q.Page, _ = strconv.Atoi(r.FormValue("page"))
+	if q.Page < 1 {
+		q.Page = 1
+	}
+	return q
+}
+
+// values encodes the query, for links to other pages and sort orders.
+func (q memberQuery) values() url.Values {
+	v := url.Values{}
+	set := func(k, s string) {
+		if s != "" {
+			v.Set(k, s)
+		}
+	}
+	set("name", q.Name)
+	set("state", q.State)
+	set("reason", q.Reason)
+	set("roles", q.RoleHolder)
+	set("minIdle", q.MinIdle)
+	set("maxIdle", q.MaxIdle)
+	set("registration", q.Registration)
+	set("sort", q.Sort)
+	if q.Desc {
+		v.Set("desc", "1")
+	}
+	if q.Page > 1 {
+		v.Set("page", strconv.Itoa(q.Page))
+	}
+	return v
+}
+
+func (q memberQuery) match(r *memberRow) bool {
+	if q.Name != "" && !strings.Contains(r.lowerName, strings.ToLower(q.Name)) {
+		return false
+	}
+	if q.State != "" {
+		state := r.State()
+		if state == "" {
+			state = "clear"
+		}
+		if state != q.State {
+			return false
+		}
+	}
+	if q.Reason != "" && !strings.Contains(strings.ToLower(r.Reason()), strings.ToLower(q.Reason)) {
+		return false
+	}
+	if (q.RoleHolder == "yes" && !r.RoleHolder) || (q.RoleHolder == "no" && r.RoleHolder) {
+		return false
+	}
+	if min, err := strconv.Atoi(q.MinIdle); err == nil && r.IdleDays() < min {
+		return false
+	}
+	if max, err := strconv.Atoi(q.MaxIdle); err == nil && r.IdleDays() > max {
+		return false
+	}
+	if q.Registration != "" && r.Registration != q.Registration {
+		return false
+	}
+	return true
+}
+
+// memberSorts orders rows by each sortable column, ascending.  Names break
+// ties because the index is already sorted by name.
+var memberSorts = map[string]func(a, b *memberRow) bool{
+	"name":      func(a, b *memberRow) bool { return a.lowerName < b.lowerName },
+	"title":     func(a, b *memberRow) bool { return a.Title < b.Title },
+	"lastLogin": func(a, b *memberRow) bool { return a.LastLogin.Before(b.LastLogin) },
+	"joined":    func(a, b *memberRow) bool { return a.Joined.Before(b.Joined) },
+	"state":     func(a, b *memberRow) bool { return a.State() < b.State() },
+	"reason":    func(a, b *memberRow) bool { return a.Reason() < b.Reason() },
+}
+
+// searchMembers returns one page of rows matching q, and the total matched.
+func searchMembers(q memberQuery) (page []memberRow, total int, built time.Time) {
+	memberIndex.RLock()
+	var rows []memberRow
+	for i := range memberIndex.rows {
+		if q.match(&memberIndex.rows[i]) {
+			rows = append(rows, memberIndex.rows[i])
+		}
+	}
+	built = memberIndex.built
+	memberIndex.RUnlock()
+
+	if less, ok := memberSorts[q.Sort]; ok {
+		sort.SliceStable(rows, func(i, j int) bool {
+			if q.Desc {
+				return less(&rows[j], &rows[i])
+			}
+			return less(&rows[i], &rows[j])
+		})
+	} else if q.Desc {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+
+	start := (q.Page - 1) * membersPerPage
+	if start > len(rows) {
+		start = len(rows)
+	}
+	end := start + membersPerPage
+	if end > len(rows) {
+		end = len(rows)
+	}
+	return rows[start:end], len(rows), built
+}
+
+// memberStates are the state filters offered, as state() values and labels.
+var memberStates = []struct{ Value, Label string }{
+	{"to strip", "To strip"},
+	{"stripped", "Stasis"},
+	{"to kick", "To boot"},
+	{"kicked", "Purged"},
+	{"claimed", "Claimed"},
+	{"on hold", "On hold"},
+	{"exempt", "Exempt"},
+	{"clear", "Not queued"},
+}
+
+var memberRegistrations = []string{"Registered", "Unregistered", "Pending", "Unknown", "Not checked"}
+
+type membersData struct {
+	Title string
+	Query memberQuery
+	Rows  []memberRow
+	Total int
+	Built time.Time
+
+	States        []struct{ Value, Label string }
+	Registrations []string
+
+	PrevURL, NextURL string
+}
+
+// SortURL links to the current search sorted by col, reversing the order if
+// it's already sorted that way.
+func (md *membersData) SortURL(col string) string {
+	q := md.Query
+	q.Desc = q.Sort == col && !q.Desc
+	q.Sort = col
+	q.Page = 1
+	return "members?" + q.values().Encode()
+}
+
+func handleMembers(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	md := &membersData{Title: "Members", Query: parseMemberQuery(r),
+		States: memberStates, Registrations: memberRegistrations}
+	md.Rows, md.Total, md.Built = searchMembers(md.Query)
+
+	if md.Query.Page > 1 {
+		q := md.Query
+		q.Page--
+		md.PrevURL = "members?" + q.values().Encode()
+	}
+	if md.Query.Page*membersPerPage < md.Total {
+		q := md.Query
+		q.Page++
+		md.NextURL = "members?" + q.values().Encode()
+	}
+
+	renderPage(w, "members", md)
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Registrations is the set of characters a tracker knows to be registered.
+type Registrations struct {
+	// Names maps normalized names to the name as registered, or "" if the
+	// tracker doesn't preserve the original spelling.
+	Names map[string]string
+
+	// IDs maps character IDs to the name as registered.  Nil if the tracker
+	// doesn't know character IDs.
+	IDs map[int64]string
+
+	// idByName maps normalized names back to character IDs where known.
+	idByName map[string]int64
+
+	// mains maps normalized names of alts to the name of their main, for
+	// trackers that know how characters are grouped.
+	mains map[string]string
+
+	// parts holds each backend's registrations when combined by a
+	// CompositeCorpMemberTracker, matching is then done per backend.
+	parts      []namedRegistrations
+	requireAll bool
+}
+
+type namedRegistrations struct {
+	name string
+	reg  *Registrations
+}
+
+// CorpMemberIDTracker is implemented by trackers that can identify
+// registered characters by character ID as well as by name.
+type CorpMemberIDTracker interface {
+	GetRegistrations() (*Registrations, error)
+}
+
+// normalizeName lower cases a character name and collapses any runs of
+// whitespace so stray spaces and case differences don't matter.
+func normalizeName(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+func newRegistrations(withIDs bool) *Registrations {
+	r := &Registrations{Names: map[string]string{}}
+	if withIDs {
+		r.IDs = map[int64]string{}
+		r.idByName = map[string]int64{}
+	}
+	return r
+}
+
+// add records a registered character, id may be 0 if unknown.
+func (r *Registrations) add(id int64, name string) {
+	name = strings.TrimSpace(name)
+	norm := normalizeName(name)
+
+	r.Names[norm] = name
+	if r.IDs != nil && id != 0 {
+		r.IDs[id] = name
+		r.idByName[norm] = id
+	}
+}
+
+// addMain records main as the main character of name.
+func (r *Registrations) addMain(name, main string) {
+	main = strings.TrimSpace(main)
+	if main == "" || normalizeName(main) == normalizeName(name) {
+		return
+	}
+	if r.mains == nil {
+		r.mains = map[string]string{}
+	}
+	r.mains[normalizeName(name)] = main
+}
+
+// mainOf returns the main of an alt, or "" if name is a main or its main
+// isn't known.
+func (r *Registrations) mainOf(name string) string {
+	if r == nil {
+		return ""
+	}
+	if main, ok := r.mains[normalizeName(name)]; ok {
+		return main
+	}
+	for _, p := range r.parts {
+		if main := p.reg.mainOf(name); main != "" {
+			return main
+		}
+	}
+	return ""
+}
+
+// memberMap flattens the registrations into the lower cased name set used by
+// CorpMemberTracker.GetMemberMap.
+func (r *Registrations) memberMap() map[string]bool {
+	if r.parts == nil {
+		m := make(map[string]bool, len(r.Names))
+		for k := range r.Names {
+			m[k] = true
+		}
+		return m
+	}
+
+	counts := map[string]int{}
+	for _, p := range r.parts {
+		for k := range p.reg.memberMap() {
+			counts[k]++
+		}
+	}
+
+	m := map[string]bool{}
+	for k, n := range counts {
+		if !r.requireAll || n == len(r.parts) {
+			m[k] = true
+		}
+	}
+	return m
+}
+
+// match reports whether mt is registered, matching on character ID first and
+// falling back to the normalized name.  mismatch describes any disagreement
+// between the two worth an operator's attention, and source names the
+// backends that vouched for the character when several are combined.
+func (r *Registrations) match(mt MemberTrackingMember) (registered bool, mismatch, source string) {
+	if r == nil {
+		return true, "", ""
+	}
+	if r.parts != nil {
+		return r.matchParts(mt)
+	}
+
+	norm := normalizeName(mt.Name)
+	if r.IDs != nil {
+		if name, ok := r.IDs[mt.CharacterID]; ok {
+			if normalizeName(name) != norm {
+				return true, fmt.Sprintf("%s (%d) is registered as %s", mt.Name, mt.CharacterID, name), ""
+			}
+			return true, "", ""
+		}
+	}
+
+	name, ok := r.Names[norm]
+	if !ok {
+		return false, "", ""
+	}
+	if id, ok := r.idByName[norm]; ok && id != mt.CharacterID {
+		return true, fmt.Sprintf("%s (%d) matched by name, but that name is registered to character ID %d", mt.Name, mt.CharacterID, id), ""
+	}
+	if name != "" && name != mt.Name {
+		return true, fmt.Sprintf("%s is registered as %s", mt.Name, name), ""
+	}
+	return true, "", ""
+}
+
+func (r *Registrations) matchParts(mt MemberTrackingMember) (registered bool, mismatch, source string) {
+	var vouched, mismatches []string
+	for _, p := range r.parts {
+		ok, mm, _ := p.reg.match(mt)
+		if !ok {
+			continue
+		}
+		vouched = append(vouched, p.name)
+		if mm != "" {
+			mismatches = append(mismatches, p.name+": "+mm)
+		}
+	}
+
+	if r.requireAll {
+		registered = len(vouched) == len(r.parts)
+	} else {
+		registered = len(vouched) > 0
+	}
+	return registered, strings.Join(mismatches, "; "), strings.Join(vouched, ", ")
+}
+
+// getRegistrations fetches registrations from cmt, using character IDs if the
+// tracker supports them.
+func getRegistrations(cmt CorpMemberTracker) (*Registrations, error) {
+	if idt, ok := cmt.(CorpMemberIDTracker); ok {
+		return idt.GetRegistrations()
+	}
+
+	m, err := cmt.GetMemberMap()
+	if err != nil {
+		return nil, err
+	}
+
+	r := newRegistrations(false)
+	for k := range m {
+		r.Names[normalizeName(k)] = ""
+	}
+	return r, nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/inominate/apicache"
+)
+
+// roleNames maps corporation role bits to their in-game names.
+var roleNames = map[int64]string{
+	1:                  "Director",
+	128:                "Personnel Manager",
+	256:                "Accountant",
+	512:                "Security Officer",
+	1024:               "Factory Manager",
+	2048:               "Station Manager",
+	4096:               "Auditor",
+	8192:               "Hangar Take 1",
+	16384:              "Hangar Take 2",
+	32768:              "Hangar Take 3",
+	65536:              "Hangar Take 4",
+	131072:             "Hangar Take 5",
+	262144:             "Hangar Take 6",
+	524288:             "Hangar Take 7",
+	1048576:            "Hangar Query 1",
+	2097152:            "Hangar Query 2",
+	4194304:            "Hangar Query 3",
+	8388608:            "Hangar Query 4",
+	16777216:           "Hangar Query 5",
+	33554432:           "Hangar Query 6",
+	67108864:           "Hangar Query 7",
+	134217728:          "Account Take 1",
+	268435456:          "Account Take 2",
+	536870912:          "Account Take 3",
+	1073741824:         "Account Take 4",
+	2147483648:         "Account Take 5",
+	4294967296:         "Account Take 6",
+	8589934592:         "Account Take 7",
+	17179869184:        "Account Query 1",
+	34359738368:        "Account Query 2",
+	68719476736:        "Account Query 3",
+	137438953472:       "Account Query 4",
+	274877906944:       "Account Query 5",
+	549755813888:       "Account Query 6",
+	1099511627776:      "Account Query 7",
+	2199023255552:      "Equipment Config",
+	4398046511104:      "Container Take 1",
+	8796093022208:      "Container Take 2",
+	17592186044416:     "Container Take 3",
+	35184372088832:     "Container Take 4",
+	70368744177664:     "Container Take 5",
+	140737488355328:    "Container Take 6",
+	281474976710656:    "Container Take 7",
+	562949953421312:    "Rent Office",
+	1125899906842624:   "Rent Factory Slot",
+	2251799813685248:   "Rent Research Slot",
+	4503599627370496:   "Junior Accountant",
+	9007199254740992:   "Starbase Config",
+	18014398509481984:  "Trader",
+	36028797018963968:  "Communications Officer",
+	72057594037927936:  "Contract Manager",
+	144115188075855872: "Infrastructure Tactical Officer",
+	288230376151711744: "Starbase Fuel Technician",
+}
+
+// decodeRoles lists the names of the roles in a bitmask, lowest bit first.
+func decodeRoles(mask int64) []string {
+	var names []string
+	for bit := uint(0); bit < 63; bit++ {
+		role := int64(1) << bit
+		if mask&role == 0 {
+			continue
+		}
+		if name, ok := roleNames[role]; ok {
+			names = append(names, name)
+		} else {
+			names = append(names, fmt.Sprintf("Unknown role %d", role))
+		}
+	}
+	return names
+}
+
+// roleSets holds every set of roles a member can have.
+type roleSets struct {
+	Roles            int64
+	Grantable        int64
+	AtHQ             int64
+	GrantableAtHQ    int64
+	AtBase           int64
+	GrantableAtBase  int64
+	AtOther          int64
+	GrantableAtOther int64
+}
+
+// namedRoles is one role set decoded for display.
+type namedRoles struct {
+	Set   string
+	Roles []string
+}
+
+func (r roleSets) any() bool {
+	return r != roleSets{}
+}
+
+// all combines every set into one bitmask.
+func (r roleSets) all() int64 {
+	return r.Roles | r.Grantable | r.AtHQ | r.GrantableAtHQ | r.AtBase |
+		r.GrantableAtBase | r.AtOther | r.GrantableAtOther
+}
+
+// named decodes each non-empty set.
+func (r roleSets) named() []namedRoles {
+	var sets []namedRoles
+	for _, s := range []struct {
+		name string
+		mask int64
+	}{
+		{"Roles", r.Roles},
+		{"Grantable", r.Grantable},
+		{"At HQ", r.AtHQ},
+		{"Grantable at HQ", r.GrantableAtHQ},
+		{"At Base", r.AtBase},
+		{"Grantable at Base", r.GrantableAtBase},
+		{"At Other", r.AtOther},
+		{"Grantable at Other", r.GrantableAtOther},
+	} {
+		if s.mask != 0 {
+			sets = append(sets, namedRoles{s.name, decodeRoles(s.mask)})
+		}
+	}
+	return sets
+}
+
+// String lists every role held, by set.
+func (r roleSets) String() string {
+	var parts []string
+	for _, s := range r.named() {
+		parts = append(parts, s.Set+": "+strings.Join(s.Roles, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// memberRoles returns mt's role sets, from member security if it could be
+// fetched or just its general and grantable roles otherwise.
+func memberRoles(mt MemberTrackingMember) roleSets {
+	if mt.Security != nil {
+		return *mt.Security
+	}
+	return roleSets{Roles: mt.Roles, Grantable: mt.GrantableRoles}
+}
+
+// reuseRoleSets copies role sets from the last pull onto members when member
+// security couldn't be fetched, so a failed fetch doesn't look like roles
+// being removed.  Returns whether the last pull had any to copy.  Must be
+// called with purgeLock held.
+func reuseRoleSets(members []MemberTrackingMember) bool {
+	last := lastPull.members
+	if last == nil {
+		// Nothing pulled since starting, try the last stored roster.
+		_, last, _ = rosterAt(time.Now())
+	}
+
+	prev := map[int64]*roleSets{}
+	for _, mt := range last {
+		if mt.Security != nil {
+			prev[mt.CharacterID] = mt.Security
+		}
+	}
+
+	for i, mt := range members {
+		if r, ok := prev[mt.CharacterID]; ok {
+			members[i].Security = r
+		}
+	}
+	return len(prev) > 0
+}
+
+// fetchRoleSets reads every member's role sets from the member security API.
+func fetchRoleSets(req *apicache.Request) (map[int64]roleSets, error) {
+	resp, err := req.Do()
+	if err != nil {
+		return nil, err
+	}
+
+	type securityRow struct {
+		CharacterID int64 `xml:"characterID,attr"`
+		Rowsets     []struct {
+			Name string `xml:"name,attr"`
+			Rows []struct {
+				RoleID int64 `xml:"roleID,attr"`
+			} `xml:"row"`
+		} `xml:"rowset"`
+	}
+	type MemberSecurity struct {
+		Members []securityRow `xml:"result>rowset>row"`
+	}
+
+	var sec MemberSecurity
+	err = xml.Unmarshal(resp.Data, &sec)
+	if err != nil {
+		return nil, err
+	}
+
+	sets := make(map[int64]roleSets, len(sec.Members))
+	for _, m := range sec.Members {
+		var r roleSets
+		for _, rs := range m.Rowsets {
+			var mask int64
+			for _, row := range rs.Rows {
+				mask |= row.RoleID
+			}
+
+			switch rs.Name {
+			case "roles":
+				r.Roles = mask
+			case "grantableRoles":
+				r.Grantable = mask
+			case "rolesAtHQ":
+				r.AtHQ = mask
+			case "grantableRolesAtHQ":
+				r.GrantableAtHQ = mask
+			case "rolesAtBase":
+				r.AtBase = mask
+			case "grantableRolesAtBase":
+				r.GrantableAtBase = mask
+			case "rolesAtOther":
+				r.AtOther = mask
+			case "grantableRolesAtOther":
+				r.GrantableAtOther = mask
+			}
+		}
+		sets[m.CharacterID] = r
+	}
+
+	return sets, nil
+}
+
+// carryStrip brings claim and strip state over from the previous list.  A
+// strip only stands once a roster pulled after it shows empty role sets,
+// otherwise the member returns to the strip queue with the roles still held.
+// With stale roles nothing is read into them and the strip state is kept
+// as it was.  Must be called with purgeLock held.
+func (m *purgeMember) carryStrip(oldm *purgeMember) {
+	if !oldm.Claimed.IsZero() {
+		m.Claimed = oldm.Claimed
+		m.ClaimedBy = oldm.ClaimedBy
+	}
+
+	switch {
+	case lastPull.staleRoles:
+		m.Stripped = oldm.Stripped
+		m.RolesAtStrip = oldm.RolesAtStrip
+		m.StillHeld = oldm.StillHeld
+	case !oldm.Stripped.IsZero() && !m.Roles:
+		m.Stripped = oldm.Stripped
+	case !oldm.Stripped.IsZero() && lastPull.pulled.Before(oldm.Stripped):
+		// Roster predates the strip, check again next pull.
+		m.Stripped = oldm.Stripped
+		m.RolesAtStrip = oldm.RolesAtStrip
+	case !oldm.Stripped.IsZero():
+		if m.RoleSets == oldm.RolesAtStrip {
+			log.Printf("%s was confirmed stripped but still holds every role.", m.Name)
+		} else {
+			log.Printf("%s was only partly stripped, still holds %s", m.Name, m.RoleSets)
+		}
+		m.StillHeld = m.RoleSets.String()
+	case oldm.StillHeld != "" && m.Roles:
+		m.StillHeld = m.RoleSets.String()
+	case oldm.Roles && !m.Roles:
+		m.Stripped = time.Now()
+	}
+}
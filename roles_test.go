@@ -0,0 +1,94 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCarryStrip(t *testing.T) {
+	now := time.Now()
+	held := roleSets{Roles: 1, AtHQ: 2048}
+	partial := roleSets{AtHQ: 2048}
+	hqOnly := roleSets{AtHQ: 2048}
+
+	tests := []struct {
+		name       string
+		pulled     time.Time
+		staleRoles bool
+		oldm       purgeMember
+		roles      roleSets
+
+		stripped     time.Time
+		strippedNow  bool
+		rolesAtStrip roleSets
+		stillHeld    string
+	}{
+		{name: "verified strip", pulled: now,
+			oldm:     purgeMember{Roles: true, Stripped: now.Add(-time.Hour), RolesAtStrip: held},
+			stripped: now.Add(-time.Hour)},
+		{name: "stale roster", pulled: now.Add(-2 * time.Hour),
+			oldm:     purgeMember{Roles: true, Stripped: now.Add(-time.Hour), RolesAtStrip: held},
+			roles:    held,
+			stripped: now.Add(-time.Hour), rolesAtStrip: held},
+		{name: "partial strip", pulled: now,
+			oldm:      purgeMember{Roles: true, Stripped: now.Add(-time.Hour), RolesAtStrip: held},
+			roles:     partial,
+			stillHeld: partial.String()},
+		{name: "still held", pulled: now,
+			oldm:      purgeMember{Roles: true, Stripped: now.Add(-time.Hour), RolesAtStrip: held},
+			roles:     held,
+			stillHeld: held.String()},
+		{name: "still held after retry", pulled: now,
+			oldm:      purgeMember{Roles: true, StillHeld: held.String()},
+			roles:     partial,
+			stillHeld: partial.String()},
+		{name: "stripped outside slopemaker", pulled: now,
+			oldm:        purgeMember{Roles: true, RoleSets: held},
+			strippedNow: true},
+		{name: "security fallback", pulled: now, staleRoles: true,
+			oldm:  purgeMember{Roles: true, RoleSets: hqOnly},
+			roles: roleSets{}},
+		{name: "security fallback after strip", pulled: now, staleRoles: true,
+			oldm:     purgeMember{Roles: true, Stripped: now.Add(-time.Hour), RolesAtStrip: held},
+			roles:    held,
+			stripped: now.Add(-time.Hour), rolesAtStrip: held},
+		{name: "security fallback while still held", pulled: now, staleRoles: true,
+			oldm:      purgeMember{Roles: true, StillHeld: held.String()},
+			stillHeld: held.String()},
+	}
+
+	saved := lastPull
+	defer func() { lastPull = saved }()
+
+	for _, tt := range tests {
+		lastPull.pulled = tt.pulled
+		lastPull.staleRoles = tt.staleRoles
+
+		m := purgeMember{Name: tt.name, RoleSets: tt.roles, Roles: tt.roles.any()}
+		oldm := tt.oldm
+		m.carryStrip(&oldm)
+
+		if tt.strippedNow {
+			if time.Since(m.Stripped) > time.Minute {
+				t.Errorf("%s: Stripped = %s, want now", tt.name, m.Stripped)
+			}
+		} else if !m.Stripped.Equal(tt.stripped) {
+			t.Errorf("%s: Stripped = %s, want %s", tt.name, m.Stripped, tt.stripped)
+		}
+		if m.RolesAtStrip != tt.rolesAtStrip {
+			t.Errorf("%s: RolesAtStrip = %s, want %s", tt.name, m.RolesAtStrip, tt.rolesAtStrip)
+		}
+		if m.StillHeld != tt.stillHeld {
+			t.Errorf("%s: StillHeld = %q, want %q", tt.name, m.StillHeld, tt.stillHeld)
+		}
+	}
+}
+
+func TestCarryStripKeepsClaim(t *testing.T) {
+	claimed := time.Now().Add(-10 * time.Minute)
+	m := purgeMember{}
+	m.carryStrip(&purgeMember{Claimed: claimed, ClaimedBy: "alice"})
+	if !m.Claimed.Equal(claimed) || m.ClaimedBy != "alice" {
+		t.Errorf("claim = %s by %q, want %s by alice", m.Claimed, m.ClaimedBy, claimed)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/robfig/config"
+)
+
+// bundledShipTypes covers the capital hulls people usually want exempted.
+// A full static data export can be used instead with shipTypesFile.
+//
+//go:embed data/shiptypes.csv
+var bundledShipTypes []byte
+
+type shipType struct {
+	ID         int64
+	Name       string
+	GroupID    int64
+	Group      string
+	CategoryID int64
+	Category   string
+}
+
+// shipTable maps ship type IDs to their group and category.
+type shipTable struct {
+	types      map[int64]*shipType
+	byName     map[string]*shipType
+	groups     map[string]int64
+	categories map[string]int64
+}
+
+// loadShipTable reads a ship type table in the format of
+// data/shiptypes.csv, or the bundled table if file is empty.
+func loadShipTable(file string) (*shipTable, error) {
+	if file == "" {
+		return parseShipTable(bytes.NewReader(bundledShipTypes))
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseShipTable(f)
+}
+
+// parseShipTable reads typeID, typeName, groupID, groupName, categoryID and
+// categoryName columns, in any order, from CSV with a header row.
+func parseShipTable(r io.Reader) (*shipTable, error) {
+	cr := csv.NewReader(r)
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("reading header: %s", err)
+	}
+
+	cols := map[string]int{}
+	for i, h := range header {
+		cols[strings.TrimSpace(h)] = i
+	}
+	for _, want := range []string{"typeID", "typeName", "groupID", "groupName", "categoryID", "categoryName"} {
+		if _, ok := cols[want]; !ok {
+			return nil, fmt.Errorf("missing column %s", want)
+		}
+	}
+
+	t := &shipTable{types: map[int64]*shipType{}, byName: map[string]*shipType{},
+		groups: map[string]int64{}, categories: map[string]int64{}}
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var st shipType
+		st.Name = rec[cols["typeName"]]
+		st.Group = rec[cols["groupName"]]
+		st.Category = rec[cols["categoryName"]]
+		st.ID, err = strconv.ParseInt(rec[cols["typeID"]], 10, 64)
+		if err == nil {
+			st.GroupID, err = strconv.ParseInt(rec[cols["groupID"]], 10, 64)
+		}
+		if err == nil {
+			st.CategoryID, err = strconv.ParseInt(rec[cols["categoryID"]], 10, 64)
+		}
+		if err != nil {
+			line, _ := cr.FieldPos(0)
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+
+		t.types[st.ID] = &st
+		t.byName[strings.ToLower(st.Name)] = &st
+		t.groups[strings.ToLower(st.Group)] = st.GroupID
+		t.categories[strings.ToLower(st.Category)] = st.CategoryID
+	}
+
+	return t, nil
+}
+
+// lookup finds the ship a member is flying, by type ID or failing that by
+// name.
+func (t *shipTable) lookup(mt MemberTrackingMember) *shipType {
+	if st, ok := t.types[mt.ShipTypeID]; ok {
+		return st
+	}
+	return t.byName[strings.ToLower(mt.ShipType)]
+}
+
+// shipExemptions are the ship types, groups and categories whose pilots are
+// never removed.
+type shipExemptions struct {
+	types      map[int64]bool
+	groups     map[int64]bool
+	categories map[int64]bool
+}
+
+// defaultShipGroups are exempted when no ship exemptions are configured.
+const defaultShipGroups = "Titan, Supercarrier"
+
+// parseShipExemptions resolves the exemptShip* options against the table,
+// accepting IDs or names.
+func parseShipExemptions(cerr *ConfigError, c *config.Config, t *shipTable) *shipExemptions {
+	s := &shipExemptions{types: map[int64]bool{}, groups: map[int64]bool{}, categories: map[int64]bool{}}
+
+	typeStr, _ := c.String("purger", "exemptShipTypes")
+	groupStr, _ := c.String("purger", "exemptShipGroups")
+	catStr, _ := c.String("purger", "exemptShipCategories")
+	if !c.HasOption("purger", "exemptShipTypes") && !c.HasOption("purger", "exemptShipGroups") &&
+		!c.HasOption("purger", "exemptShipCategories") {
+		groupStr = defaultShipGroups
+	}
+
+	for _, v := range splitList(typeStr) {
+		if id, err := strconv.ParseInt(v, 10, 64); err == nil {
+			s.types[id] = true
+		} else if st, ok := t.byName[strings.ToLower(v)]; ok {
+			s.types[st.ID] = true
+		} else {
+			cerr.add("[purger] exemptShipTypes entry '%s' is not a known ship type", v)
+		}
+	}
+
+	for _, v := range splitList(groupStr) {
+		if id, err := strconv.ParseInt(v, 10, 64); err == nil {
+			s.groups[id] = true
+		} else if id, ok := t.groups[strings.ToLower(v)]; ok {
+			s.groups[id] = true
+		} else {
+			cerr.add("[purger] exemptShipGroups entry '%s' is not a known ship group", v)
+		}
+	}
+
+	for _, v := range splitList(catStr) {
+		if id, err := strconv.ParseInt(v, 10, 64); err == nil {
+			s.categories[id] = true
+		} else if id, ok := t.categories[strings.ToLower(v)]; ok {
+			s.categories[id] = true
+		} else {
+			cerr.add("[purger] exemptShipCategories entry '%s' is not a known ship category", v)
+		}
+	}
+
+	return s
+}
+
+// exempt reports whether mt is flying an exempted ship.
+func (s *shipExemptions) exempt(mt MemberTrackingMember, t *shipTable) bool {
+	if s == nil {
+		return false
+	}
+	if s.types[mt.ShipTypeID] {
+		return true
+	}
+
+	st := t.lookup(mt)
+	if st == nil {
+		return false
+	}
+	return s.types[st.ID] || s.groups[st.GroupID] || s.categories[st.CategoryID]
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqlDrivers maps the driver setting to the database/sql driver name.
+var sqlDrivers = map[string]string{
+	"":           "mysql",
+	"mysql":      "mysql",
+	"postgres":   "postgres",
+	"postgresql": "postgres",
+	"sqlite":     "sqlite3",
+	"sqlite3":    "sqlite3",
+}
+
+// sqlDriver returns the database/sql driver name for a driver setting.
+func sqlDriver(setting string) (string, error) {
+	driver, ok := sqlDrivers[strings.ToLower(setting)]
+	if !ok {
+		return "", fmt.Errorf("unknown driver '%s', expected mysql, postgres or sqlite", setting)
+	}
+	return driver, nil
+}
+
+// rebind rewrites the ? placeholders used in the config into the style the
+// driver expects.  Placeholders inside quoted strings are left alone.
+func rebind(driver, query string) string {
+	if driver != "postgres" {
+		return query
+	}
+
+	var b strings.Builder
+	var quote rune
+	n := 0
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '\'' || r == '"':
+			quote = r
+		case r == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
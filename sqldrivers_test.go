@@ -0,0 +1,126 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestRebind(t *testing.T) {
+	tests := []struct {
+		driver, query, want string
+	}{
+		{"postgres", "SELECT name FROM chars WHERE name = ?",
+			"SELECT name FROM chars WHERE name = $1"},
+		{"postgres", "SELECT name FROM chars WHERE name = ? AND corp = ? AND note <> '?' AND \"a?\" = ?",
+			"SELECT name FROM chars WHERE name = $1 AND corp = $2 AND note <> '?' AND \"a?\" = $3"},
+		{"sqlite3", "SELECT name FROM chars WHERE name = ? AND corp = ?",
+			"SELECT name FROM chars WHERE name = ? AND corp = ?"},
+		{"mysql", "SELECT name FROM chars WHERE name = ?",
+			"SELECT name FROM chars WHERE name = ?"},
+	}
+	for _, tt := range tests {
+		if got := rebind(tt.driver, tt.query); got != tt.want {
+			t.Errorf("rebind(%s, %q) = %q, want %q", tt.driver, tt.query, got, tt.want)
+		}
+	}
+}
+
+// openTestDB creates a SQLite registration database in a temporary file.
+func openTestDB(t *testing.T) *sql.DB {
+	driver, err := sqlDriver("sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := sql.Open(driver, filepath.Join(t.TempDir(), "auth.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	for _, stmt := range []string{
+		"CREATE TABLE chars (id INTEGER, name TEXT, main TEXT)",
+		"INSERT INTO chars VALUES (90001, 'Alice Alpha', NULL)",
+		"INSERT INTO chars VALUES (90002, 'Alice Alt', 'Alice Alpha')",
+		"INSERT INTO chars VALUES (NULL, 'Bob  Bravo', NULL)",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func newTestSQLTracker(t *testing.T, allQuery string) *SQLCorpMemberTracker {
+	return NewSQLCorpMemberTracker(openTestDB(t), rebind("sqlite3", allQuery),
+		rebind("sqlite3", "SELECT name FROM chars WHERE name = ? COLLATE NOCASE"))
+}
+
+func TestSQLTrackerNames(t *testing.T) {
+	s := newTestSQLTracker(t, "SELECT name FROM chars")
+
+	reg, err := s.GetRegistrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reg.Names) != 3 || reg.Names["bob bravo"] != "Bob  Bravo" || reg.IDs != nil {
+		t.Errorf("Registrations = %v, %v, want three names without IDs", reg.Names, reg.IDs)
+	}
+	if main := reg.mainOf("Alice Alt"); main != "" {
+		t.Errorf("mainOf(Alice Alt) = %q without a main column", main)
+	}
+}
+
+func TestSQLTrackerIDs(t *testing.T) {
+	s := newTestSQLTracker(t, "SELECT id, name FROM chars")
+
+	reg, err := s.GetRegistrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reg.Names) != 3 || len(reg.IDs) != 2 || reg.IDs[90002] != "Alice Alt" {
+		t.Errorf("Registrations = %v, %v, want three names and two IDs", reg.Names, reg.IDs)
+	}
+
+	registered, mismatch, _ := reg.match(MemberTrackingMember{CharacterID: 90001, Name: "Alice Renamed"})
+	if !registered || mismatch == "" {
+		t.Errorf("renamed character: registered %v, mismatch %q", registered, mismatch)
+	}
+}
+
+func TestSQLTrackerMains(t *testing.T) {
+	s := newTestSQLTracker(t, "SELECT id, name, main FROM chars")
+
+	reg, err := s.GetRegistrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reg.Names) != 3 || len(reg.IDs) != 2 {
+		t.Errorf("Registrations = %v, %v, want three names and two IDs", reg.Names, reg.IDs)
+	}
+	if main := reg.mainOf("alice alt"); main != "Alice Alpha" {
+		t.Errorf("mainOf(alice alt) = %q, want Alice Alpha", main)
+	}
+	if main := reg.mainOf("Alice Alpha"); main != "" {
+		t.Errorf("mainOf(Alice Alpha) = %q, want none", main)
+	}
+}
+
+func TestSQLTrackerIsRegistered(t *testing.T) {
+	s := newTestSQLTracker(t, "SELECT name FROM chars")
+
+	for name, want := range map[string]bool{"Alice Alpha": true, "alice alt": true, "Carol Charlie": false} {
+		got, err := s.IsRegistered(name)
+		if err != nil || got != want {
+			t.Errorf("IsRegistered(%q) = %v, %v, want %v", name, got, err, want)
+		}
+	}
+
+	s = NewSQLCorpMemberTracker(openTestDB(t), "", "SELECT name FROM missing WHERE name = ?")
+	if _, err := s.IsRegistered("Alice Alpha"); err == nil {
+		t.Errorf("IsRegistered succeeded against a missing table")
+	}
+	if _, err := s.GetRegistrations(); err == nil {
+		t.Errorf("GetRegistrations succeeded without all_query")
+	}
+}
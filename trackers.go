@@ -0,0 +1,257 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/robfig/config"
+)
+
+const defaultBackendTimeout = 30 * time.Second
+
+// trackerSections lists the config sections describing registration
+// backends.  With no backends option the [registered_characters] section is
+// itself the only backend, otherwise each named backend has its own
+// [registered_characters_<name>] section.
+func trackerSections(c *config.Config) (names, sections []string) {
+	list, _ := c.String("registered_characters", "backends")
+	names = splitList(list)
+	if len(names) == 0 {
+		return []string{""}, []string{"registered_characters"}
+	}
+
+	for _, name := range names {
+		sections = append(sections, "registered_characters_"+name)
+	}
+	return names, sections
+}
+
+// validateTrackers checks the registration backend settings.
+func validateTrackers(cerr *ConfigError, c *config.Config) {
+	mode, _ := c.String("registered_characters", "mode")
+	if mode != "" && mode != "any" && mode != "all" {
+		cerr.add("[registered_characters] mode '%s' must be any or all", mode)
+	}
+
+	names, sections := trackerSections(c)
+	for i, section := range sections {
+		memberDSN, _ := c.String(section, "DSN")
+		memberURL, _ := c.String(section, "URL")
+		memberLDAP, _ := c.String(section, "LDAP")
+		count := 0
+		for _, v := range []string{memberDSN, memberURL, memberLDAP} {
+			if v != "" {
+				count++
+			}
+		}
+		if count > 1 {
+			cerr.add("[%s] specify only one of DSN, URL or LDAP", section)
+		}
+		if names[i] != "" && count == 0 {
+			cerr.add("[%s] backend %s needs a DSN, URL or LDAP", section, names[i])
+		}
+		if memberLDAP != "" {
+			_, err := ldapTrackerOptions(c, section)
+			if err != nil {
+				cerr.add("[%s] %s", section, err)
+			}
+		}
+		if memberURL != "" && !validURL(memberURL) {
+			cerr.add("[%s] URL '%s' is not a valid http(s) URL", section, memberURL)
+		}
+		if memberDSN != "" {
+			setting, _ := c.String(section, "driver")
+			if _, err := sqlDriver(setting); err != nil {
+				cerr.add("[%s] %s", section, err)
+			}
+			for _, q := range []string{"all_query", "single_query"} {
+				query, _ := c.String(section, q)
+				if query == "" {
+					cerr.add("[%s] DSN specified but no %s found", section, q)
+				}
+			}
+		}
+
+		if memberURL != "" {
+			_, err := httpTrackerOptions(c, section)
+			if err != nil {
+				cerr.add("[%s] %s", section, err)
+			}
+		}
+
+		timeout, _ := c.String(section, "timeout")
+		if timeout != "" {
+			if d, err := time.ParseDuration(timeout); err != nil || d <= 0 {
+				cerr.add("[%s] timeout '%s' is not a valid duration", section, timeout)
+			}
+		}
+	}
+}
+
+// httpTrackerOptions reads the HTTP tracker settings from a backend section.
+func httpTrackerOptions(c *config.Config, section string) (HTTPTrackerOptions, error) {
+	var opts HTTPTrackerOptions
+
+	opts.Format, _ = c.String(section, "format")
+	if opts.Format == "" {
+		opts.Format = "text"
+	}
+	if opts.Format != "text" && opts.Format != "json" {
+		return opts, fmt.Errorf("format '%s' must be text or json", opts.Format)
+	}
+	opts.JSONPath, _ = c.String(section, "json_path")
+	opts.NameField, _ = c.String(section, "json_name")
+	opts.IDField, _ = c.String(section, "json_id")
+	opts.MainField, _ = c.String(section, "json_main")
+
+	refresh, _ := c.String(section, "refresh")
+	if refresh != "" {
+		d, err := time.ParseDuration(refresh)
+		if err != nil || d <= 0 {
+			return opts, fmt.Errorf("refresh '%s' is not a valid duration", refresh)
+		}
+		opts.Refresh = d
+	}
+
+	opts.Headers = http.Header{}
+	headers, _ := c.String(section, "headers")
+	for _, h := range splitList(headers) {
+		colon := strings.Index(h, ":")
+		if colon <= 0 {
+			return opts, fmt.Errorf("header '%s' should be Name: value", h)
+		}
+		opts.Headers.Add(strings.TrimSpace(h[:colon]), strings.TrimSpace(h[colon+1:]))
+	}
+
+	token, _ := c.String(section, "bearer_token")
+	if token != "" {
+		opts.Headers.Set("Authorization", "Bearer "+token)
+	}
+	basic, _ := c.String(section, "basic_auth")
+	if basic != "" {
+		colon := strings.Index(basic, ":")
+		if colon < 0 {
+			return opts, fmt.Errorf("basic_auth should be user:password")
+		}
+		req := http.Request{Header: http.Header{}}
+		req.SetBasicAuth(basic[:colon], basic[colon+1:])
+		opts.Headers.Set("Authorization", req.Header.Get("Authorization"))
+	}
+
+	return opts, nil
+}
+
+// ldapTrackerOptions reads the LDAP tracker settings from a backend section.
+func ldapTrackerOptions(c *config.Config, section string) (LDAPTrackerOptions, error) {
+	var opts LDAPTrackerOptions
+
+	u, _ := c.String(section, "LDAP")
+	if pu, err := url.Parse(u); err != nil || (pu.Scheme != "ldap" && pu.Scheme != "ldaps") {
+		return opts, fmt.Errorf("LDAP '%s' should be an ldap:// or ldaps:// URL", u)
+	}
+
+	opts.BindDN, _ = c.String(section, "bind_dn")
+	opts.BindPassword, _ = c.String(section, "bind_password")
+	opts.BaseDN, _ = c.String(section, "base_dn")
+	if opts.BaseDN == "" {
+		return opts, fmt.Errorf("LDAP specified but no base_dn found")
+	}
+	opts.Filter, _ = c.String(section, "filter")
+	opts.NameAttr, _ = c.String(section, "name_attr")
+	opts.IDAttr, _ = c.String(section, "id_attr")
+
+	if c.HasOption(section, "start_tls") {
+		var err error
+		opts.StartTLS, err = c.Bool(section, "start_tls")
+		if err != nil {
+			return opts, fmt.Errorf("start_tls must be true or false")
+		}
+	}
+
+	refresh, _ := c.String(section, "refresh")
+	if refresh != "" {
+		d, err := time.ParseDuration(refresh)
+		if err != nil || d <= 0 {
+			return opts, fmt.Errorf("refresh '%s' is not a valid duration", refresh)
+		}
+		opts.Refresh = d
+	}
+
+	return opts, nil
+}
+
+// newBackend builds a tracker from a single backend section, returning nil
+// if it specifies none of DSN, URL or LDAP.
+func newBackend(c *config.Config, section string) (CorpMemberTracker, error) {
+	memberDSN, _ := c.String(section, "DSN")
+	memberURL, _ := c.String(section, "URL")
+	memberLDAP, _ := c.String(section, "LDAP")
+
+	if memberDSN != "" {
+		setting, _ := c.String(section, "driver")
+		driver, err := sqlDriver(setting)
+		if err != nil {
+			return nil, err
+		}
+
+		memberDB, err := sql.Open(driver, memberDSN)
+		if err != nil {
+			return nil, fmt.Errorf("could not open database: %s", err)
+		}
+
+		allQuery, _ := c.String(section, "all_query")
+		singleQuery, _ := c.String(section, "single_query")
+		return NewSQLCorpMemberTracker(memberDB, rebind(driver, allQuery), rebind(driver, singleQuery)), nil
+	} else if memberURL != "" {
+		opts, err := httpTrackerOptions(c, section)
+		if err != nil {
+			return nil, err
+		}
+		return NewHTTPCorpMemberTracker(memberURL, opts), nil
+	} else if memberLDAP != "" {
+		opts, err := ldapTrackerOptions(c, section)
+		if err != nil {
+			return nil, err
+		}
+		return NewLDAPCorpMemberTracker(memberLDAP, opts), nil
+	}
+
+	return nil, nil
+}
+
+// newTrackerFromConfig builds the registration tracker described by the
+// config, or nil if registration checking isn't enabled.
+func newTrackerFromConfig(c *config.Config) (CorpMemberTracker, error) {
+	names, sections := trackerSections(c)
+	if names[0] == "" {
+		return newBackend(c, sections[0])
+	}
+
+	mode, _ := c.String("registered_characters", "mode")
+	composite := NewCompositeCorpMemberTracker(mode == "all")
+	for i, section := range sections {
+		t, err := newBackend(c, section)
+		if err != nil {
+			return nil, fmt.Errorf("backend %s: %s", names[i], err)
+		}
+		if t == nil {
+			return nil, fmt.Errorf("backend %s needs a DSN, URL or LDAP", names[i])
+		}
+
+		timeout := defaultBackendTimeout
+		if str, _ := c.String(section, "timeout"); str != "" {
+			timeout, err = time.ParseDuration(str)
+			if err != nil {
+				return nil, fmt.Errorf("backend %s: %s", names[i], err)
+			}
+		}
+
+		composite.Add(names[i], t, timeout)
+	}
+
+	return composite, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// undoWindow is how long a confirmed strip or kick can be undone.
+const undoWindow = 24 * time.Hour
+
+// confirmation is a strip or kick confirmed by an operator, along with the
+// state it replaced so it can be undone.
+type confirmation struct {
+	Time        time.Time
+	User        string
+	Action      string
+	CharacterID int64
+	Name        string
+
+	Stripped     time.Time
+	RolesAtStrip roleSets
+	Purged       bool
+
+	Undone bool
+}
+
+// confirmations made within undoWindow, oldest first.  Saved with the rest of
+// the state, protected by purgeLock.
+var confirmations []confirmation
+
+// noteConfirmation remembers m's state before action is confirmed at t.  Must
+// be called with purgeLock held.
+func noteConfirmation(action, user string, m *purgeMember, t time.Time) {
+	for len(confirmations) > 0 && time.Since(confirmations[0].Time) > undoWindow {
+		confirmations = confirmations[1:]
+	}
+
+	confirmations = append(confirmations, confirmation{Time: t, User: user, Action: action,
+		CharacterID: m.Id, Name: m.Name, Stripped: m.Stripped,
+		RolesAtStrip: m.RolesAtStrip, Purged: m.Purged})
+}
+
+// undoableConfirmations lists user's recent confirmations of action, newest
+// first.  Admins see everyone's.  Must be called with purgeLock held.
+func undoableConfirmations(action, user string) []confirmation {
+	admin := isAdminLocked(user)
+
+	var recent []confirmation
+	for i := len(confirmations) - 1; i >= 0; i-- {
+		c := confirmations[i]
+		if time.Since(c.Time) > undoWindow {
+			break
+		}
+		if c.Action == action && !c.Undone && (admin || c.User == user) {
+			recent = append(recent, c)
+		}
+	}
+	return recent
+}
+
+// undoConfirmation restores the state a confirmation replaced.  Must be
+// called with purgeLock held.
+func undoConfirmation(at time.Time, id int64, user string) error {
+	var c *confirmation
+	for i := range confirmations {
+		if confirmations[i].CharacterID == id && confirmations[i].Time.Equal(at) {
+			c = &confirmations[i]
+		}
+	}
+	if c == nil || c.Undone || time.Since(c.Time) > undoWindow {
+		return errors.New("That confirmation can no longer be undone.")
+	}
+	if c.User != user && !isAdminLocked(user) {
+		return fmt.Errorf("Only %s or an admin can undo that.", c.User)
+	}
+
+	m, ok := toBePurged[id]
+	if !ok {
+		return fmt.Errorf("%s is no longer queued.", c.Name)
+	}
+
+	switch c.Action {
+	case "stripped":
+		if !m.Stripped.Equal(c.Time) {
+			return fmt.Errorf("%s has been updated since the strip was confirmed.", c.Name)
+		}
+		m.Stripped = c.Stripped
+		m.RolesAtStrip = c.RolesAtStrip
+	case "kicked":
+		if !m.Purged {
+			return fmt.Errorf("%s has been updated since the kick was confirmed.", c.Name)
+		}
+		m.Purged = c.Purged
+	}
+	c.Undone = true
+
+	log.Printf("%s undid %s confirming %s as %s.", user, c.User, c.Name, c.Action)
+	recordAudit(user, "undid "+c.Action, id, c.Name,
+		fmt.Sprintf("Confirmed by %s at %s", c.User, formatTime(c.Time)))
+	return nil
+}
+
+func handleUndo(w http.ResponseWriter, r *http.Request, ses Session) {
+	r.ParseForm()
+
+	back := "strip"
+	if r.PostFormValue("page") == "boot" {
+		back = "boot"
+	}
+
+	at, _ := strconv.ParseInt(r.PostFormValue("at"), 10, 64)
+	id, _ := strconv.ParseInt(r.PostFormValue("character"), 10, 64)
+
+	purgeLock.Lock()
+	err := undoConfirmation(time.Unix(0, at), id, ses.Get("username"))
+	if err == nil {
+		queueSave()
+	}
+	purgeLock.Unlock()
+
+	if err != nil {
+		ses.Set("undoError", err.Error())
+	}
+	w.Header().Set("Location", back)
+	w.WriteHeader(http.StatusFound)
+}
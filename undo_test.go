@@ -0,0 +1,136 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// setupPurgeState gives a test an empty queue, a scratch database and one
+// idle role holder in the last pull.
+func setupPurgeState(t *testing.T) int64 {
+	db, err := bolt.Open(filepath.Join(t.TempDir(), "purger.db"), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bdb = db
+	t.Cleanup(func() {
+		saveWG.Wait()
+		lastPull.members = nil
+		db.Close()
+	})
+
+	toBePurged = map[int64]*purgeMember{}
+	confirmations = nil
+	admins = []string{"admin"}
+	maxIdle = 30 * 24 * time.Hour
+	lastPull.members = []MemberTrackingMember{{CharacterID: 42, Name: "Idle Ivan",
+		StartDateTime: APITime{time.Now().Add(-400 * 24 * time.Hour)},
+		LogonDateTime: APITime{time.Now().Add(-90 * 24 * time.Hour)},
+		Roles:         1}}
+	lastPull.pulled = time.Now().Add(-time.Minute)
+	lastPull.staleRoles = false
+
+	reevaluateRoster()
+	if toBePurged[42] == nil {
+		t.Fatal("idle member wasn't queued")
+	}
+	return 42
+}
+
+// confirm marks id as stripped or kicked by user, as the strip and boot
+// pages do.
+func confirm(action, user string, id int64) time.Time {
+	purgeLock.Lock()
+	defer purgeLock.Unlock()
+
+	m, now := toBePurged[id], time.Now()
+	noteConfirmation(action, user, m, now)
+	if action == "stripped" {
+		m.Stripped = now
+		m.RolesAtStrip = m.RoleSets
+	} else {
+		m.Purged = true
+	}
+	return now
+}
+
+func undo(at time.Time, id int64, user string) error {
+	purgeLock.Lock()
+	defer purgeLock.Unlock()
+	return undoConfirmation(at, id, user)
+}
+
+func TestUndoStrip(t *testing.T) {
+	id := setupPurgeState(t)
+	at := confirm("stripped", "alice", id)
+
+	if err := undo(at, id, "bob"); err == nil {
+		t.Errorf("bob undid alice's strip")
+	}
+	if err := undo(at, id, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	m := toBePurged[id]
+	if !m.Stripped.IsZero() || m.RolesAtStrip != (roleSets{}) {
+		t.Errorf("after undo Stripped = %s, RolesAtStrip = %s", m.Stripped, m.RolesAtStrip)
+	}
+	if err := undo(at, id, "alice"); err == nil {
+		t.Errorf("the same strip was undone twice")
+	}
+	if recent := undoableConfirmations("stripped", "alice"); len(recent) != 0 {
+		t.Errorf("undone strip still listed: %v", recent)
+	}
+}
+
+func TestUndoKick(t *testing.T) {
+	id := setupPurgeState(t)
+	at := confirm("kicked", "alice", id)
+
+	if recent := undoableConfirmations("kicked", "admin"); len(recent) != 1 {
+		t.Errorf("admin sees %d kicks to undo, want 1", len(recent))
+	}
+	if err := undo(at, id, "admin"); err != nil {
+		t.Fatal(err)
+	}
+	if toBePurged[id].Purged {
+		t.Errorf("still marked kicked after undo")
+	}
+}
+
+func TestUndoAfterRebuild(t *testing.T) {
+	id := setupPurgeState(t)
+
+	stripped := confirm("stripped", "alice", id)
+	reevaluateRoster()
+	if !toBePurged[id].Stripped.Equal(stripped) {
+		t.Fatalf("rebuild dropped the strip: %s", toBePurged[id].Stripped)
+	}
+	if err := undo(stripped, id, "alice"); err != nil {
+		t.Errorf("undo strip after rebuild: %s", err)
+	}
+	if !toBePurged[id].Stripped.IsZero() {
+		t.Errorf("strip still stands after undo")
+	}
+
+	kicked := confirm("kicked", "alice", id)
+	reevaluateRoster()
+	if !toBePurged[id].Purged {
+		t.Fatalf("rebuild dropped the kick")
+	}
+	if err := undo(kicked, id, "alice"); err != nil {
+		t.Errorf("undo kick after rebuild: %s", err)
+	}
+	if toBePurged[id].Purged {
+		t.Errorf("kick still stands after undo")
+	}
+
+	// A strip overtaken by a newer one can't be undone.
+	old := confirm("stripped", "alice", id)
+	confirm("stripped", "alice", id)
+	if err := undo(old, id, "alice"); err == nil {
+		t.Errorf("undid a strip that was confirmed again since")
+	}
+}